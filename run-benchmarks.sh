@@ -0,0 +1,63 @@
+#!/bin/bash
+#
+# Times compression of a large bundle built from the given stylesheets, by
+# default the corpus in src/testdata, repeated until it is about 2 MB. Set
+# BASELINE to another gocss binary to compare against it. The Go benchmarks
+# of the pipeline use the same corpus, those of the lexer and the parser one
+# stylesheet of it:
+#
+#   gotest -bench . src/lexer src/parser src/main
+#
+#   ./run-benchmarks.sh
+#   BASELINE=../gocss-old/gocss ./run-benchmarks.sh css/*.css
+
+size=${SIZE:-2000000}
+runs=${RUNS:-5}
+gocss=${GOCSS:-./gocss}
+
+if [ $# -eq 0 ]; then
+  set -- src/testdata/*.css
+fi
+
+bundle=$(mktemp /tmp/gocss-bench.XXXXXX)
+trap 'rm -f $bundle' EXIT
+
+while [ $(wc -c < $bundle) -lt $size ]; do
+  for f in "$@"; do
+    cat "$f" >> $bundle
+    echo >> $bundle
+  done
+done
+
+# best wall clock time of a number of runs, in milliseconds
+function best {
+  local min=
+  for i in $(seq $runs); do
+    start=$(date +%s%N)
+    "$@" < $bundle > /dev/null 2>&1
+    end=$(date +%s%N)
+    t=$(( (end - start) / 1000000 ))
+    if [ -z "$min" ] || [ $t -lt $min ]; then min=$t; fi
+  done
+  echo $min
+}
+
+function report {
+  local name="$1"
+  shift
+  local t=$(best "$@")
+  awk -v name="$name" -v t=$t -v n=$(wc -c < $bundle) \
+    'BEGIN { printf "%-30s %6d ms %6.1f MB/s\n", name, t, n / 1000 / (t > 0 ? t : 1) }'
+}
+
+echo "$(wc -c < $bundle) bytes from $# files, best of $runs runs"
+if [ -n "$BASELINE" ]; then
+  report "baseline" $BASELINE -i
+  report "baseline rtl" $BASELINE -i -r
+fi
+report "compress" $gocss -i
+report "compress, concurrent" $gocss -i -p
+report "compress yui" $gocss -i -y
+report "rtl" $gocss -i -r
+report "bidi" $gocss -i -d
+report "beautify" $gocss -i -b
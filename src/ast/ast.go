@@ -0,0 +1,168 @@
+// Syntax tree for stylesheets, modelled after CSS Syntax Level 3
+package ast
+
+import (
+	"./lexer"
+	"bytes"
+)
+
+type Node interface {
+	Pos() lexer.Position
+}
+
+type Stylesheet struct {
+	Rules []Node
+}
+
+// Single token, function or simple block
+type ComponentValue struct {
+	// the token itself, the function token or the opening bracket
+	Token    lexer.TokenValue
+	// contents of a function or block
+	Children []*ComponentValue
+	// false for a function or block the input ended in
+	Closed   bool
+}
+
+// Comma separated part of a rule's prelude, whitespace around it is trimmed
+type Selector struct {
+	Values []*ComponentValue
+}
+
+type QualifiedRule struct {
+	Position  lexer.Position
+	Selectors []*Selector
+	Block     *Block
+}
+
+type AtRule struct {
+	Position lexer.Position
+	// name without the @, as written
+	Name     string
+	Prelude  []*ComponentValue
+	// nil for statements like @import or @charset
+	Block    *Block
+}
+
+type Declaration struct {
+	Position  lexer.Position
+	Property  string
+	// value without !important and the whitespace around it
+	Value     []*ComponentValue
+	Important bool
+}
+
+type Comment struct {
+	Position lexer.Position
+	Text     string
+}
+
+type BlockKind int
+const (
+	// component values of an at-rule we don't know the grammar of
+	ValueBlock BlockKind = iota
+	// declarations, possibly mixed with at-rules and comments
+	DeclarationBlock
+	// rules, as in @media
+	RuleBlock
+)
+
+type Block struct {
+	Kind         BlockKind
+	Rules        []Node
+	Declarations []Node
+	Values       []*ComponentValue
+}
+
+func (r *QualifiedRule) Pos() lexer.Position { return r.Position }
+func (r *AtRule) Pos() lexer.Position { return r.Position }
+func (d *Declaration) Pos() lexer.Position { return d.Position }
+func (c *Comment) Pos() lexer.Position { return c.Position }
+
+func (cv *ComponentValue) Is(t lexer.Token) bool {
+	return cv.Token.Token == t
+}
+
+func (cv *ComponentValue) IsFunction() bool {
+	return cv.Token.Token == lexer.Function
+}
+
+func (cv *ComponentValue) IsBlock() bool {
+	switch cv.Token.Token {
+	case lexer.LeftBrace, lexer.LeftBracket, lexer.LeftParen:
+		return true
+	}
+	return false
+}
+
+// Text closing a block or function opened by t
+func Closing(t lexer.Token) string {
+	switch t {
+	case lexer.LeftBrace:
+		return "}"
+	case lexer.LeftBracket:
+		return "]"
+	}
+	return ")"
+}
+
+func (cv *ComponentValue) write(b *bytes.Buffer) {
+	b.WriteString(cv.Token.Value)
+	if !cv.IsFunction() && !cv.IsBlock() { return }
+	writeValues(b, cv.Children)
+	if cv.Closed { b.WriteString(Closing(cv.Token.Token)) }
+}
+
+// Source text of the value, as it was written
+func (cv *ComponentValue) String() string {
+	var b bytes.Buffer
+	cv.write(&b)
+	return b.String()
+}
+
+func writeValues(b *bytes.Buffer, values []*ComponentValue) {
+	for i, cv := range values {
+		if i > 0 && Joins(values[i-1].Token, cv.Token) { b.WriteString("/**/") }
+		cv.write(b)
+	}
+}
+
+// Source text of a list of values, with an empty comment where a dropped
+// one kept two tokens apart
+func Serialize(values []*ComponentValue) string {
+	var b bytes.Buffer
+	writeValues(&b, values)
+	return b.String()
+}
+
+// True when a written right before b would be read as other tokens, the
+// pairs CSS Syntax Level 3 separates with a comment when serializing
+func Joins(a, b lexer.TokenValue) bool {
+	numeric := b.Token == lexer.Number || b.Token == lexer.Percentage || b.Token == lexer.Dimension
+	name := b.Token == lexer.Identifier || b.Token == lexer.Function || b.Token == lexer.URL || b.Token == lexer.BadURL
+	minus := b.Token == lexer.Delim && b.Value == "-"
+	switch a.Token {
+	case lexer.Identifier:
+		return name || minus || numeric || b.Token == lexer.CDC || b.Token == lexer.LeftParen
+	case lexer.AtKeyword, lexer.Hash, lexer.Dimension:
+		return name || minus || numeric || b.Token == lexer.CDC
+	case lexer.Number:
+		return name || numeric || b.Token == lexer.Delim && b.Value == "%"
+	case lexer.Plus, lexer.Period:
+		return numeric
+	case lexer.Delim:
+		switch a.Value {
+		case "#", "-":
+			return name || minus || numeric
+		case "@":
+			return name || minus
+		case "/":
+			return b.Token == lexer.Star
+		}
+	}
+	return false
+}
+
+func (s *Selector) String() string {
+	return Serialize(s.Values)
+}
@@ -0,0 +1,315 @@
+package ast
+
+import (
+	"./diag"
+	"./lexer"
+	"strings"
+)
+
+// at-rules whose block holds rules, vendor prefixes are ignored
+var RULE_AT_RULES = map[string] bool {
+	"media":          true,
+	"supports":       true,
+	"document":       true,
+	"layer":          true,
+	"container":      true,
+	"scope":          true,
+	"starting-style": true,
+	"keyframes":      true,
+}
+
+// at-rules whose block holds declarations
+var DECLARATION_AT_RULES = map[string] bool {
+	"font-face":          true,
+	"page":               true,
+	"viewport":           true,
+	"counter-style":      true,
+	"property":           true,
+	"font-palette-values": true,
+	// margin boxes inside @page
+	"top-left-corner":     true,
+	"top-left":            true,
+	"top-center":          true,
+	"top-right":           true,
+	"top-right-corner":    true,
+	"bottom-left-corner":  true,
+	"bottom-left":         true,
+	"bottom-center":       true,
+	"bottom-right":        true,
+	"bottom-right-corner": true,
+	"left-top":            true,
+	"left-middle":         true,
+	"left-bottom":         true,
+	"right-top":           true,
+	"right-middle":        true,
+	"right-bottom":        true,
+}
+
+// Builds a Stylesheet from a token stream, recovering from errors the way
+// CSS Syntax Level 3 describes
+type Builder struct {
+	In   chan(lexer.TokenValue)
+	// when set, problems in the input are reported there
+	Diag     *diag.Reporter
+	// comments in selectors, preludes and values are kept, to print the
+	// stylesheet as it was written
+	Comments bool
+	tv       lexer.TokenValue
+	done     bool
+}
+
+func CreateBuilder(in chan(lexer.TokenValue)) (b *Builder) {
+	b = &Builder{In: in}
+	return
+}
+
+func (b *Builder) warning(tv lexer.TokenValue, format string, args ...interface{}) {
+	if b.Diag != nil { b.Diag.Warning(tv, format, args...) }
+}
+
+// Reads the whole token stream
+func (b *Builder) Build() (sheet *Stylesheet) {
+	values := b.values(lexer.EndToken)
+	sheet = &Stylesheet{Rules: b.rules(values, true)}
+	return
+}
+
+// Name of an at-rule without its vendor prefix
+func Unprefixed(name string) string {
+	name = strings.ToLower(name)
+	if len(name) > 1 && name[0] == '-' {
+		if i := strings.Index(name[1:], "-"); i >= 0 {
+			return name[i+2:]
+		}
+	}
+	return name
+}
+
+func (b *Builder) next() lexer.TokenValue {
+	// the lexer sends a single end token, keep returning it
+	if !b.done {
+		b.tv = <- b.In
+		b.done = b.tv.Token == lexer.EndToken
+	}
+	return b.tv
+}
+
+// component values up to the closing token of the current block
+func (b *Builder) values(end lexer.Token) (values []*ComponentValue) {
+	for {
+		tv := b.next()
+		switch {
+		case tv.Token == end && end != lexer.EndToken:
+			return
+		case tv.Token == lexer.EndToken:
+			if end != lexer.EndToken {
+				b.warning(tv, "unexpected end of file, missing %s", end)
+			}
+			return
+		}
+		values = append(values, b.value(tv))
+	}
+	panic("unreachable")
+}
+
+func (b *Builder) value(tv lexer.TokenValue) (cv *ComponentValue) {
+	cv = &ComponentValue{Token: tv, Closed: true}
+	switch tv.Token {
+	case lexer.Function, lexer.LeftParen:
+		cv.Children = b.uncommented(b.values(lexer.RightParen))
+	case lexer.LeftBrace:
+		// comments in blocks of rules and declarations are kept
+		cv.Children = b.values(lexer.RightBrace)
+	case lexer.LeftBracket:
+		cv.Children = b.uncommented(b.values(lexer.RightBracket))
+	default:
+		return
+	}
+	cv.Closed = b.tv.Token != lexer.EndToken
+	return
+}
+
+func isSpace(cv *ComponentValue) bool {
+	return cv.Is(lexer.Whitespace)
+}
+
+// Drops the comments, the spec never gives them to the parser. Tokens that
+// would run together are kept apart by Serialize.
+func (b *Builder) uncommented(values []*ComponentValue) (kept []*ComponentValue) {
+	if b.Comments { return values }
+	for _, cv := range values {
+		if !cv.Is(lexer.Comment) { kept = append(kept, cv) }
+	}
+	return
+}
+
+func trim(values []*ComponentValue) []*ComponentValue {
+	for len(values) > 0 && isSpace(values[0]) {
+		values = values[1:]
+	}
+	for len(values) > 0 && isSpace(values[len(values)-1]) {
+		values = values[:len(values)-1]
+	}
+	return values
+}
+
+// list of rules, CDO and CDC are only skipped at the top level
+func (b *Builder) rules(values []*ComponentValue, top bool) (rules []Node) {
+	for i := 0; i < len(values); {
+		cv := values[i]
+		switch {
+		case isSpace(cv):
+			i++
+		case top && (cv.Is(lexer.CDO) || cv.Is(lexer.CDC)):
+			i++
+		case cv.Is(lexer.Comment):
+			rules = append(rules, &Comment{cv.Token.Pos, cv.Token.Value})
+			i++
+		case cv.Is(lexer.AtKeyword):
+			var rule *AtRule
+			rule, i = b.atRule(values, i)
+			rules = append(rules, rule)
+		default:
+			var rule *QualifiedRule
+			if rule, i = b.qualifiedRule(values, i); rule != nil {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return
+}
+
+func (b *Builder) atRule(values []*ComponentValue, i int) (rule *AtRule, next int) {
+	tv := values[i].Token
+	rule = &AtRule{Position: tv.Pos, Name: tv.Value[1:]}
+	for i++; i < len(values); i++ {
+		cv := values[i]
+		switch {
+		case cv.Is(lexer.Semicolon):
+			rule.Prelude = trim(rule.Prelude)
+			return rule, i + 1
+		case cv.Is(lexer.LeftBrace):
+			rule.Prelude = trim(rule.Prelude)
+			rule.Block = b.block(cv, Unprefixed(rule.Name))
+			return rule, i + 1
+		case cv.Is(lexer.Comment) && !b.Comments:
+			continue
+		}
+		rule.Prelude = append(rule.Prelude, cv)
+	}
+	rule.Prelude = trim(rule.Prelude)
+	return rule, i
+}
+
+func (b *Builder) qualifiedRule(values []*ComponentValue, i int) (rule *QualifiedRule, next int) {
+	start := i
+	for ; i < len(values); i++ {
+		if cv := values[i]; cv.Is(lexer.LeftBrace) {
+			rule = &QualifiedRule{Position: values[start].Token.Pos}
+			rule.Selectors = selectors(b.uncommented(values[start:i]))
+			rule.Block = b.declarationBlock(cv.Children)
+			return rule, i + 1
+		}
+	}
+	b.warning(values[start].Token, "rule without a block, ignoring it")
+	return nil, i
+}
+
+func selectors(prelude []*ComponentValue) (selectors []*Selector) {
+	start := 0
+	for i, cv := range prelude {
+		if cv.Is(lexer.Comma) {
+			selectors = append(selectors, &Selector{trim(prelude[start:i])})
+			start = i + 1
+		}
+	}
+	selectors = append(selectors, &Selector{trim(prelude[start:])})
+	return
+}
+
+func (b *Builder) block(cv *ComponentValue, name string) (block *Block) {
+	switch {
+	case RULE_AT_RULES[name]:
+		block = &Block{Kind: RuleBlock, Rules: b.rules(cv.Children, false)}
+	case DECLARATION_AT_RULES[name]:
+		block = b.declarationBlock(cv.Children)
+	default:
+		block = &Block{Kind: ValueBlock, Values: cv.Children}
+	}
+	return
+}
+
+func (b *Builder) declarationBlock(values []*ComponentValue) (block *Block) {
+	block = &Block{Kind: DeclarationBlock}
+	for i := 0; i < len(values); {
+		cv := values[i]
+		switch {
+		case isSpace(cv) || cv.Is(lexer.Semicolon):
+			i++
+		case cv.Is(lexer.Comment):
+			block.Declarations = append(block.Declarations, &Comment{cv.Token.Pos, cv.Token.Value})
+			i++
+		case cv.Is(lexer.AtKeyword):
+			var rule *AtRule
+			rule, i = b.atRule(values, i)
+			block.Declarations = append(block.Declarations, rule)
+		default:
+			start := i
+			for i < len(values) && !values[i].Is(lexer.Semicolon) {
+				i++
+			}
+			// *zoom, a hack for old IE, is kept as a property of that name
+			hack := cv.Is(lexer.Star) && start + 1 < i && values[start+1].Is(lexer.Identifier)
+			if !cv.Is(lexer.Identifier) && !hack {
+				b.warning(cv.Token, "expected a declaration, skipping to the next ;")
+				continue
+			}
+			if d := b.declaration(b.uncommented(values[start:i])); d != nil {
+				block.Declarations = append(block.Declarations, d)
+			}
+		}
+	}
+	return
+}
+
+func (b *Builder) declaration(values []*ComponentValue) (d *Declaration) {
+	name, rest := values[0].Token, values[1:]
+	if name.Token == lexer.Star {
+		name.Value += rest[0].Token.Value
+		rest = rest[1:]
+	}
+	// comments before the colon are kept in front of the value
+	var lead []*ComponentValue
+	for rest = trim(rest); len(rest) > 0 && rest[0].Is(lexer.Comment); rest = trim(rest[1:]) {
+		lead = append(lead, rest[0])
+	}
+	if len(rest) == 0 || !rest[0].Is(lexer.Colon) {
+		b.warning(name, "expected : after %s, ignoring the declaration", name.Value)
+		return nil
+	}
+	d = &Declaration{Position: name.Pos, Property: name.Value}
+	value := trim(append(lead, rest[1:]...))
+	// !important, possibly with whitespace and comments between the two
+	if i := last(value, len(value)); i >= 0 && value[i].Is(lexer.Identifier) && strings.ToLower(value[i].Token.Value) == "important" {
+		if j := last(value, i); j >= 0 && value[j].Is(lexer.Bang) {
+			d.Important = true
+			// comments around it stay at the end of the value
+			kept := append([]*ComponentValue(nil), trim(value[:j])...)
+			for _, cv := range value[j+1:] {
+				if cv.Is(lexer.Comment) { kept = append(kept, cv) }
+			}
+			value = kept
+		}
+	}
+	d.Value = value
+	return
+}
+
+// index of the last value before end that isn't whitespace or a comment, -1
+// when there is none
+func last(values []*ComponentValue, end int) int {
+	for i := end - 1; i >= 0; i-- {
+		if !isSpace(values[i]) && !values[i].Is(lexer.Comment) { return i }
+	}
+	return -1
+}
@@ -0,0 +1,158 @@
+package ast
+
+import (
+	"./lexer"
+	"bytes"
+	"testing"
+)
+
+func build(css string, comments bool) *Stylesheet {
+	in := make(chan(int))
+	lex, out := lexer.CreateLexer(in)
+	go func() {
+		for _, c := range css {
+			in <- c
+		}
+		in <- -1
+	}()
+	go lex.Run()
+	b := CreateBuilder(out)
+	b.Comments = comments
+	return b.Build()
+}
+
+// the tree written back without whitespace between nodes, declarations end
+// in ; and comments are written as #text
+func dump(b *bytes.Buffer, nodes []Node) {
+	for _, node := range nodes {
+		switch n := node.(type) {
+		case *Comment:
+			b.WriteString("#" + n.Text)
+		case *Declaration:
+			b.WriteString(n.Property + ":" + Serialize(n.Value))
+			if n.Important { b.WriteString("!important") }
+			b.WriteString(";")
+		case *QualifiedRule:
+			for i, s := range n.Selectors {
+				if i > 0 { b.WriteString(",") }
+				b.WriteString(s.String())
+			}
+			dumpBlock(b, n.Block)
+		case *AtRule:
+			b.WriteString("@" + n.Name + "(" + Serialize(n.Prelude) + ")")
+			if n.Block == nil {
+				b.WriteString(";")
+			} else {
+				dumpBlock(b, n.Block)
+			}
+		}
+	}
+}
+
+func dumpBlock(b *bytes.Buffer, block *Block) {
+	b.WriteString("{")
+	switch block.Kind {
+	case RuleBlock:
+		dump(b, block.Rules)
+	case DeclarationBlock:
+		dump(b, block.Declarations)
+	default:
+		b.WriteString(Serialize(block.Values))
+	}
+	b.WriteString("}")
+}
+
+var buildTests = []struct{ in, out string }{
+	{"a{color:red}", "a{color:red;}"},
+	{"a , b>c{color : red ; ;margin:0 auto}", "a,b>c{color:red;margin:0 auto;}"},
+	{"a{color:red!important;b:c ! IMPORTANT}", "a{color:red!important;b:c!important;}"},
+	{"a{color}b{c:d}", "a{}b{c:d;}"},
+	{"a{:b;c:d}", "a{c:d;}"},
+	{"a{*zoom:1;_height:0;*:x;+b:c}", "a{*zoom:1;_height:0;}"},
+	{"a{b:c", "a{b:c;}"},
+	// the function runs to the end, its } is not the block's
+	{"a{b:f(c}", "a{b:f(c};}"},
+	{"a", ""},
+	{"<!--a{b:c}-->", "a{b:c;}"},
+	{"@import 'a.css';@media screen{a{b:c}}", "@import('a.css');@media(screen){a{b:c;}}"},
+	{"@font-face{font-family:x}@page :first{margin:0;@top-left{content:'a'}}",
+		"@font-face(){font-family:x;}@page(:first){margin:0;@top-left(){content:'a';}}"},
+	{"@-webkit-keyframes x{0%{a:b}}", "@-webkit-keyframes(x){0%{a:b;}}"},
+	{"@foo x{a b}", "@foo(x){a b}"},
+	// comments between rules and declarations are kept, those in values dropped
+	{"/*a*/a{/*b*/c:d}", "#/*a*/a{#/*b*/c:d;}"},
+	{"a{color:/*x*/red}", "a{color:red;}"},
+	{"a{color/*x*/:red}", "a{color:red;}"},
+	{"a{color:red/*x*/!important}", "a{color:red!important;}"},
+	{"a{color:red!/*x*/important}", "a{color:red!important;}"},
+	{"a{color:red !important/*x*/}", "a{color:red!important;}"},
+	{"a{b:f(/*x*/1px)[/*y*/c]}", "a{b:f(1px)[c];}"},
+	{"a/*x*/,b/**/.c{d:e}", "a,b.c{d:e;}"},
+	{"@media/*x*/screen{}", "@media(screen){}"},
+	// tokens a dropped comment kept apart stay apart
+	{"a{b:1px/**/2px;c:x/**/y;d:1/**/%;e:calc(1px+/**/2px)}", "a{b:1px/**/2px;c:x/**/y;d:1/**/%;e:calc(1px+/**/2px);}"},
+	{"a/**/b{c:d}", "a/**/b{c:d;}"},
+}
+
+func TestBuild(t *testing.T) {
+	for _, test := range buildTests {
+		var b bytes.Buffer
+		dump(&b, build(test.in, false).Rules)
+		if b.String() != test.out {
+			t.Errorf("%q: built %q, want %q", test.in, b.String(), test.out)
+		}
+	}
+}
+
+// with Comments, those in selectors, preludes and values are kept as well
+var commentTests = []struct{ in, out string }{
+	{"/*a*/a{/*b*/c:d}", "#/*a*/a{#/*b*/c:d;}"},
+	{"a{color:/*x*/red}", "a{color:/*x*/red;}"},
+	{"a{color/*x*/:red}", "a{color:/*x*/red;}"},
+	{"a{color:red /*x*/ !important}", "a{color:red /*x*/!important;}"},
+	{"a{color:red!/*x*/important}", "a{color:red/*x*/!important;}"},
+	{"a{color:red !important/*x*/}", "a{color:red/*x*/!important;}"},
+	{"a{color:red/*!important*/}", "a{color:red/*!important*/;}"},
+	{"a{b:f(/*x*/1px)[/*y*/c]}", "a{b:f(/*x*/1px)[/*y*/c];}"},
+	{"a/*x*/,b/**/.c{d:e}", "a/*x*/,b/**/.c{d:e;}"},
+	{"a>/**/b{c:d}", "a>/**/b{c:d;}"},
+	{"@media/*x*/screen{}", "@media(/*x*/screen){}"},
+}
+
+func TestBuildComments(t *testing.T) {
+	for _, test := range commentTests {
+		var b bytes.Buffer
+		dump(&b, build(test.in, true).Rules)
+		if b.String() != test.out {
+			t.Errorf("%q: built %q, want %q", test.in, b.String(), test.out)
+		}
+	}
+}
+
+func TestJoins(t *testing.T) {
+	for _, test := range []struct {
+		a, b  string
+		joins bool
+	}{
+		{"a", "b", true}, {"a", "-", true}, {"a", "1", true}, {"a", "(", true}, {"a", ",", false},
+		{"1", "2", true}, {"1", "%", true}, {"1", "-", false}, {"1px", "-", true},
+		{"#a", "b", true}, {"@a", "1", true}, {"+", "1", true}, {".", "a", false},
+		{"/", "*", true}, {"*", "/", false}, {"-", "-", true}, {"@", "a", true}, {"'a'", "b", false},
+	} {
+		a, b := lexer.Tokenize(test.a)[0], lexer.Tokenize(test.b)[0]
+		if Joins(a, b) != test.joins {
+			t.Errorf("%q %q: joins is %v", test.a, test.b, !test.joins)
+		}
+	}
+}
+
+// positions of rules and declarations are those of their first token
+func TestPositions(t *testing.T) {
+	sheet := build("a{\n  b:c}\n@x;", false)
+	r := sheet.Rules[0].(*QualifiedRule)
+	d := r.Block.Declarations[0].(*Declaration)
+	at := sheet.Rules[1].(*AtRule)
+	if r.Pos().Line != 1 || d.Pos().Line != 2 || d.Pos().Column != 3 || at.Pos().Line != 3 {
+		t.Errorf("positions are %v %v %v", r.Pos(), d.Pos(), at.Pos())
+	}
+}
@@ -0,0 +1,145 @@
+// Pretty printer for stylesheets
+package beautify
+
+import (
+	"./ast"
+	"./lexer"
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+)
+
+type Printer struct {
+	Out    *bufio.Writer
+	// written once per nesting level
+	Indent string
+	depth  int
+}
+
+func CreatePrinter(out io.Writer, indent string) (p *Printer) {
+	p = &Printer{Out: bufio.NewWriter(out), Indent: indent}
+	return
+}
+
+// Writes the stylesheet with one declaration per line, blank lines between
+// rules and nested blocks indented. Minifying the output gives the same
+// result as minifying the original.
+func Print(sheet *ast.Stylesheet, out io.Writer, indent string) os.Error {
+	p := CreatePrinter(out, indent)
+	return p.Print(sheet)
+}
+
+// returns the first error writing the output
+func (p *Printer) Print(sheet *ast.Stylesheet) os.Error {
+	p.rules(sheet.Rules)
+	return p.Out.Flush()
+}
+
+func (p *Printer) indent() {
+	p.Out.WriteString(strings.Repeat(p.Indent, p.depth))
+}
+
+// Source text of the values with each run of whitespace written as a single space
+func Value(list []*ast.ComponentValue) string {
+	var b bytes.Buffer
+	values(&b, list)
+	return b.String()
+}
+
+func values(b *bytes.Buffer, list []*ast.ComponentValue) {
+	for i, cv := range list {
+		if i > 0 && ast.Joins(list[i-1].Token, cv.Token) { b.WriteString("/**/") }
+		value(b, cv)
+	}
+}
+
+func value(b *bytes.Buffer, cv *ast.ComponentValue) {
+	if cv.Is(lexer.Whitespace) {
+		b.WriteString(" ")
+		return
+	}
+	b.WriteString(cv.Token.Value)
+	if !cv.IsFunction() && !cv.IsBlock() { return }
+	children := cv.Children
+	// url( "a" ) is written as url("a")
+	for len(children) > 0 && children[0].Is(lexer.Whitespace) {
+		children = children[1:]
+	}
+	for len(children) > 0 && children[len(children)-1].Is(lexer.Whitespace) {
+		children = children[:len(children)-1]
+	}
+	values(b, children)
+	if cv.Closed { b.WriteString(ast.Closing(cv.Token.Token)) }
+}
+
+func (p *Printer) rules(rules []ast.Node) {
+	for i, rule := range rules {
+		if i > 0 {
+			// comments stay attached to the rule following them
+			if _, ok := rules[i-1].(*ast.Comment); !ok {
+				p.Out.WriteString("\n")
+			}
+		}
+		p.node(rule)
+	}
+}
+
+func (p *Printer) node(n ast.Node) {
+	switch n := n.(type) {
+	case *ast.Comment:
+		p.indent()
+		p.Out.WriteString(n.Text)
+		p.Out.WriteString("\n")
+	case *ast.Declaration:
+		p.indent()
+		p.Out.WriteString(n.Property)
+		p.Out.WriteString(": ")
+		p.Out.WriteString(Value(n.Value))
+		if n.Important { p.Out.WriteString(" !important") }
+		p.Out.WriteString(";\n")
+	case *ast.QualifiedRule:
+		for i, s := range n.Selectors {
+			if i > 0 { p.Out.WriteString(",\n") }
+			p.indent()
+			p.Out.WriteString(Value(s.Values))
+		}
+		p.block(n.Block)
+	case *ast.AtRule:
+		p.indent()
+		p.Out.WriteString("@")
+		p.Out.WriteString(n.Name)
+		if len(n.Prelude) > 0 {
+			p.Out.WriteString(" ")
+			p.Out.WriteString(Value(n.Prelude))
+		}
+		if n.Block == nil {
+			p.Out.WriteString(";\n")
+			return
+		}
+		p.block(n.Block)
+	}
+}
+
+func (p *Printer) block(b *ast.Block) {
+	p.Out.WriteString(" {\n")
+	p.depth++
+	switch b.Kind {
+	case ast.RuleBlock:
+		p.rules(b.Rules)
+	case ast.DeclarationBlock:
+		for _, d := range b.Declarations {
+			p.node(d)
+		}
+	default:
+		if v := strings.TrimSpace(Value(b.Values)); v != "" {
+			p.indent()
+			p.Out.WriteString(v)
+			p.Out.WriteString("\n")
+		}
+	}
+	p.depth--
+	p.indent()
+	p.Out.WriteString("}\n")
+}
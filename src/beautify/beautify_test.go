@@ -0,0 +1,96 @@
+package beautify
+
+import (
+	"./ast"
+	"./lexer"
+	"./parser"
+	"bytes"
+	"testing"
+)
+
+func tokens(css string) chan(lexer.TokenValue) {
+	in := make(chan(int))
+	lex, out := lexer.CreateLexer(in)
+	go func() {
+		for _, c := range css {
+			in <- c
+		}
+		in <- -1
+	}()
+	go lex.Run()
+	return out
+}
+
+func build(css string) *ast.Stylesheet {
+	b := ast.CreateBuilder(tokens(css))
+	b.Comments = true
+	return b.Build()
+}
+
+func beautify(css string) string {
+	var b bytes.Buffer
+	Print(build(css), &b, "  ")
+	return b.String()
+}
+
+func compress(css string) string {
+	p, out := parser.CreateParser(tokens(css), false)
+	go p.Run()
+	var b bytes.Buffer
+	for s := <- out; s != ""; s = <- out {
+		b.WriteString(s)
+	}
+	return b.String()
+}
+
+var printTests = []struct{ in, out string }{
+	{"a{color:red;margin:0  auto}", "a {\n  color: red;\n  margin: 0 auto;\n}\n"},
+	{"a,b>c{x:y!important}d{}", "a,\nb>c {\n  x: y !important;\n}\n\nd {\n}\n"},
+	{"/*a*/a{b:c}", "/*a*/\na {\n  b: c;\n}\n"},
+	{"@media screen{a{b:url( \"x\" )}}", "@media screen {\n  a {\n    b: url(\"x\");\n  }\n}\n"},
+	{"@import 'a.css';@font-face{font-family:x}", "@import 'a.css';\n\n@font-face {\n  font-family: x;\n}\n"},
+	{"@foo x{a  b}", "@foo x {\n  a b\n}\n"},
+	// comments in selectors, preludes and values are written as they were
+	{"a>/**/b{c:d}", "a>/**/b {\n  c: d;\n}\n"},
+	{"a /* x */ , b{c:/*y*/d  /* z */}", "a /* x */,\nb {\n  c: /*y*/d /* z */;\n}\n"},
+	{"@media /*x*/ screen{a{b:c!/**/important}}", "@media /*x*/ screen {\n  a {\n    b: c/**/ !important;\n  }\n}\n"},
+}
+
+func TestPrint(t *testing.T) {
+	for _, test := range printTests {
+		if out := beautify(test.in); out != test.out {
+			t.Errorf("%q: printed\n%s\nwant\n%s", test.in, out, test.out)
+		}
+	}
+}
+
+// minifying the beautified stylesheet gives what minifying the original does
+var roundTripTests = []string{
+	"a{color:red;margin:0  auto}",
+	"a , b > c ~ d + e{color:#FF0000;background:url(a.png) no-repeat 0 0}",
+	"a[href^='http'],a:not(.b)::before{content:'a  b'}",
+	"/*! keep */\na{b:c}/* drop */d{e:f}",
+	"a{b:c!important;d:e ! important}",
+	"@charset \"utf-8\";@import url(a.css) screen;@media screen and (max-width:100px){a{b:c}}",
+	"@font-face{font-family:x;src:url(a.woff) format('woff')}",
+	"@page :first{margin:1in}",
+	"@-webkit-keyframes x{from{opacity:0}50%{opacity:.5}to{opacity:1}}",
+	"a{*zoom:1;_height:1px;filter:progid:DXImageTransform.Microsoft.Alpha(Opacity=80)}",
+	"a{width:calc(100% - 2px);margin:-1px 0 0 -1px;font:12px/1.5 a,b}",
+	"a{b:rgb(255, 0, 0);c:0.50em;d:0px}",
+	"a{}b{c:d}",
+	// the child selector hack for old IE, and comments the minifier keeps
+	"a>/**/b{c:d}",
+	"a/* x */b,c/**/>d{e:f/* g */h}",
+	"a{b:c/*! keep */!important}",
+	"@media /* x */ screen{a{b:c}}",
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, css := range roundTripTests {
+		pretty := beautify(css)
+		if a, b := compress(css), compress(pretty); a != b {
+			t.Errorf("%q: minified\n%s\nbeautified and minified\n%s\nbeautified\n%s", css, a, b, pretty)
+		}
+	}
+}
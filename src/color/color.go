@@ -0,0 +1,456 @@
+// Parsing CSS colors and writing them in their shortest form
+package color
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// components of colors that aren't within this of a whole number can't be
+// written in hex
+const EPSILON = 1e-6
+
+var (
+	// CSS Color Module Level 4 named colors
+	NAMES = map[string] uint32 {
+		"aliceblue":            0xf0f8ff,
+		"antiquewhite":         0xfaebd7,
+		"aqua":                 0x00ffff,
+		"aquamarine":           0x7fffd4,
+		"azure":                0xf0ffff,
+		"beige":                0xf5f5dc,
+		"bisque":               0xffe4c4,
+		"black":                0x000000,
+		"blanchedalmond":       0xffebcd,
+		"blue":                 0x0000ff,
+		"blueviolet":           0x8a2be2,
+		"brown":                0xa52a2a,
+		"burlywood":            0xdeb887,
+		"cadetblue":            0x5f9ea0,
+		"chartreuse":           0x7fff00,
+		"chocolate":            0xd2691e,
+		"coral":                0xff7f50,
+		"cornflowerblue":       0x6495ed,
+		"cornsilk":             0xfff8dc,
+		"crimson":              0xdc143c,
+		"cyan":                 0x00ffff,
+		"darkblue":             0x00008b,
+		"darkcyan":             0x008b8b,
+		"darkgoldenrod":        0xb8860b,
+		"darkgray":             0xa9a9a9,
+		"darkgreen":            0x006400,
+		"darkgrey":             0xa9a9a9,
+		"darkkhaki":            0xbdb76b,
+		"darkmagenta":          0x8b008b,
+		"darkolivegreen":       0x556b2f,
+		"darkorange":           0xff8c00,
+		"darkorchid":           0x9932cc,
+		"darkred":              0x8b0000,
+		"darksalmon":           0xe9967a,
+		"darkseagreen":         0x8fbc8f,
+		"darkslateblue":        0x483d8b,
+		"darkslategray":        0x2f4f4f,
+		"darkslategrey":        0x2f4f4f,
+		"darkturquoise":        0x00ced1,
+		"darkviolet":           0x9400d3,
+		"deeppink":             0xff1493,
+		"deepskyblue":          0x00bfff,
+		"dimgray":              0x696969,
+		"dimgrey":              0x696969,
+		"dodgerblue":           0x1e90ff,
+		"firebrick":            0xb22222,
+		"floralwhite":          0xfffaf0,
+		"forestgreen":          0x228b22,
+		"fuchsia":              0xff00ff,
+		"gainsboro":            0xdcdcdc,
+		"ghostwhite":           0xf8f8ff,
+		"gold":                 0xffd700,
+		"goldenrod":            0xdaa520,
+		"gray":                 0x808080,
+		"green":                0x008000,
+		"greenyellow":          0xadff2f,
+		"grey":                 0x808080,
+		"honeydew":             0xf0fff0,
+		"hotpink":              0xff69b4,
+		"indianred":            0xcd5c5c,
+		"indigo":               0x4b0082,
+		"ivory":                0xfffff0,
+		"khaki":                0xf0e68c,
+		"lavender":             0xe6e6fa,
+		"lavenderblush":        0xfff0f5,
+		"lawngreen":            0x7cfc00,
+		"lemonchiffon":         0xfffacd,
+		"lightblue":            0xadd8e6,
+		"lightcoral":           0xf08080,
+		"lightcyan":            0xe0ffff,
+		"lightgoldenrodyellow": 0xfafad2,
+		"lightgray":            0xd3d3d3,
+		"lightgreen":           0x90ee90,
+		"lightgrey":            0xd3d3d3,
+		"lightpink":            0xffb6c1,
+		"lightsalmon":          0xffa07a,
+		"lightseagreen":        0x20b2aa,
+		"lightskyblue":         0x87cefa,
+		"lightslategray":       0x778899,
+		"lightslategrey":       0x778899,
+		"lightsteelblue":       0xb0c4de,
+		"lightyellow":          0xffffe0,
+		"lime":                 0x00ff00,
+		"limegreen":            0x32cd32,
+		"linen":                0xfaf0e6,
+		"magenta":              0xff00ff,
+		"maroon":               0x800000,
+		"mediumaquamarine":     0x66cdaa,
+		"mediumblue":           0x0000cd,
+		"mediumorchid":         0xba55d3,
+		"mediumpurple":         0x9370db,
+		"mediumseagreen":       0x3cb371,
+		"mediumslateblue":      0x7b68ee,
+		"mediumspringgreen":    0x00fa9a,
+		"mediumturquoise":      0x48d1cc,
+		"mediumvioletred":      0xc71585,
+		"midnightblue":         0x191970,
+		"mintcream":            0xf5fffa,
+		"mistyrose":            0xffe4e1,
+		"moccasin":             0xffe4b5,
+		"navajowhite":          0xffdead,
+		"navy":                 0x000080,
+		"oldlace":              0xfdf5e6,
+		"olive":                0x808000,
+		"olivedrab":            0x6b8e23,
+		"orange":               0xffa500,
+		"orangered":            0xff4500,
+		"orchid":               0xda70d6,
+		"palegoldenrod":        0xeee8aa,
+		"palegreen":            0x98fb98,
+		"paleturquoise":        0xafeeee,
+		"palevioletred":        0xdb7093,
+		"papayawhip":           0xffefd5,
+		"peachpuff":            0xffdab9,
+		"peru":                 0xcd853f,
+		"pink":                 0xffc0cb,
+		"plum":                 0xdda0dd,
+		"powderblue":           0xb0e0e6,
+		"purple":               0x800080,
+		"rebeccapurple":        0x663399,
+		"red":                  0xff0000,
+		"rosybrown":            0xbc8f8f,
+		"royalblue":            0x4169e1,
+		"saddlebrown":          0x8b4513,
+		"salmon":               0xfa8072,
+		"sandybrown":           0xf4a460,
+		"seagreen":             0x2e8b57,
+		"seashell":             0xfff5ee,
+		"sienna":               0xa0522d,
+		"silver":               0xc0c0c0,
+		"skyblue":              0x87ceeb,
+		"slateblue":            0x6a5acd,
+		"slategray":            0x708090,
+		"slategrey":            0x708090,
+		"snow":                 0xfffafa,
+		"springgreen":          0x00ff7f,
+		"steelblue":            0x4682b4,
+		"tan":                  0xd2b48c,
+		"teal":                 0x008080,
+		"thistle":              0xd8bfd8,
+		"tomato":               0xff6347,
+		"turquoise":            0x40e0d0,
+		"violet":               0xee82ee,
+		"wheat":                0xf5deb3,
+		"white":                0xffffff,
+		"whitesmoke":           0xf5f5f5,
+		"yellow":               0xffff00,
+		"yellowgreen":          0x9acd32,
+	}
+	FUNCTIONS = map[string] bool {
+		"rgb(":  true,
+		"rgba(": true,
+		"hsl(":  true,
+		"hsla(": true,
+		"hwb(":  true,
+	}
+	// properties other than *-color and *-shadow that take colors
+	PROPERTY_PREFIXES = []string{"background", "border", "outline", "column-rule", "text-decoration",
+		"text-emphasis", "text-stroke", "mask", "fill", "stroke"}
+)
+
+// shortest name of each color, the first in alphabetical order of those as short
+var shortNames = make(map[uint32] string)
+
+func init() {
+	for name, v := range NAMES {
+		old, found := shortNames[v]
+		if !found || len(name) < len(old) || (len(name) == len(old) && name < old) { shortNames[v] = name }
+	}
+}
+
+// sRGB color, components from 0 to 255 and alpha from 0 to 1
+type Color struct {
+	R, G, B, A float64
+}
+
+// True for the functions Parse knows, with their opening parenthesis
+func IsFunction(name string) bool {
+	return FUNCTIONS[strings.ToLower(name)]
+}
+
+// True for properties whose values may contain named colors, vendor
+// prefixes are ignored
+func IsProperty(property string) bool {
+	p := strings.ToLower(property)
+	if len(p) > 1 && p[0] == '-' && p[1] != '-' {
+		if i := strings.Index(p[1:], "-"); i >= 0 { p = p[i+2:] }
+	}
+	if strings.Index(p, "color") >= 0 || strings.HasSuffix(p, "shadow") { return true }
+	for _, prefix := range PROPERTY_PREFIXES {
+		if p == prefix || strings.HasPrefix(p, prefix + "-") { return true }
+	}
+	return false
+}
+
+// Parses a hex color, a named color or a color function. Arguments of
+// functions are separated by commas, or by whitespace with the alpha after
+// a /. Keywords like currentColor and functions with other arguments aren't
+// colors.
+func Parse(s string) (c Color, ok bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch {
+	case s == "":
+		return
+	case s[0] == '#':
+		return parseHex(s[1:])
+	case s == "transparent":
+		return Color{0, 0, 0, 0}, true
+	case s[len(s)-1] != ')':
+		v, found := NAMES[s]
+		return rgb(v), found
+	}
+
+	i := strings.Index(s, "(")
+	if i < 0 || !FUNCTIONS[s[:i+1]] { return }
+	name := strings.TrimRight(s[:i], "a")
+	args, alpha, legacy, ok := split(s[i+1:len(s)-1])
+	if !ok { return }
+	c.A = 1
+	if alpha != "" {
+		if c.A, ok = fraction(alpha, 1); !ok { return }
+	}
+	switch name {
+	case "rgb":
+		return parseRGB(args, c.A, legacy)
+	case "hsl":
+		return parseHSL(args, c.A, legacy)
+	case "hwb":
+		if legacy { return c, false }
+		return parseHWB(args, c.A)
+	}
+	return c, false
+}
+
+// #rgb, #rgba, #rrggbb and #rrggbbaa
+func parseHex(digits string) (c Color, ok bool) {
+	n := len(digits)
+	if n != 3 && n != 4 && n != 6 && n != 8 { return }
+	v, err := strconv.Btoui64(digits, 16)
+	if err != nil { return }
+	if n <= 4 {
+		// every digit doubled
+		var wide uint64
+		for i := 0; i < n; i++ {
+			d := (v >> uint(4 * (n - 1 - i))) & 0xf
+			wide = wide << 8 | d << 4 | d
+		}
+		v, n = wide, n * 2
+	}
+	if n == 6 { v = v << 8 | 0xff }
+	return Color{float64(v >> 24), float64(v >> 16 & 0xff), float64(v >> 8 & 0xff), float64(v & 0xff) / 255}, true
+}
+
+func rgb(v uint32) Color {
+	return Color{float64(v >> 16), float64(v >> 8 & 0xff), float64(v & 0xff), 1}
+}
+
+// Splits the arguments of a function into three components and the alpha,
+// legacy is set for the comma separated syntax
+func split(s string) (args []string, alpha string, legacy, ok bool) {
+	if strings.Index(s, ",") >= 0 {
+		if strings.Index(s, "/") >= 0 { return }
+		args = strings.Split(s, ",")
+		for i := range args {
+			args[i] = strings.TrimSpace(args[i])
+		}
+		legacy = true
+	} else {
+		parts := strings.Split(s, "/")
+		if len(parts) > 2 { return }
+		args = strings.Fields(parts[0])
+		if len(parts) == 2 {
+			if alpha = strings.TrimSpace(parts[1]); alpha == "" { return }
+		}
+	}
+	if legacy && len(args) == 4 { args, alpha = args[:3], args[3] }
+	return args, alpha, legacy, len(args) == 3
+}
+
+// A number, or a percentage of max. The result is clamped to 0 to max.
+func fraction(s string, max float64) (f float64, ok bool) {
+	f, ok = number(s, "%")
+	if !ok {
+		if f, ok = number(s, ""); !ok { return }
+	} else {
+		f = f * max / 100
+	}
+	return math.Fmin(math.Fmax(f, 0), max), true
+}
+
+// the number before unit at the end of s
+func number(s, unit string) (f float64, ok bool) {
+	if !strings.HasSuffix(s, unit) { return }
+	s = s[:len(s)-len(unit)]
+	if s == "" || strings.IndexAny(s[len(s)-1:], "0123456789.") < 0 { return }
+	f, err := strconv.Atof64(s)
+	return f, err == nil
+}
+
+func isPercentage(s string) bool {
+	return strings.HasSuffix(s, "%")
+}
+
+// rgb(r, g, b), all numbers or all percentages with commas
+func parseRGB(args []string, alpha float64, legacy bool) (c Color, ok bool) {
+	if legacy && (isPercentage(args[0]) != isPercentage(args[1]) || isPercentage(args[0]) != isPercentage(args[2])) { return }
+	v := make([]float64, 3)
+	for i, arg := range args {
+		if v[i], ok = fraction(arg, 255); !ok { return }
+	}
+	return Color{v[0], v[1], v[2], alpha}, true
+}
+
+// hue in degrees, from a number or an angle
+func hue(s string) (h float64, ok bool) {
+	units := []struct { unit string; degrees float64 } {
+		{"deg", 1}, {"grad", 0.9}, {"rad", 180 / math.Pi}, {"turn", 360}, {"", 1},
+	}
+	for _, u := range units {
+		if h, ok = number(s, u.unit); ok {
+			h = math.Fmod(h * u.degrees, 360)
+			if h < 0 { h += 360 }
+			return
+		}
+	}
+	return
+}
+
+// saturation, lightness, whiteness and blackness from 0 to 1, commas need percentages
+func percentages(args []string, legacy bool) (v []float64, ok bool) {
+	v = make([]float64, len(args))
+	for i, arg := range args {
+		if legacy && !isPercentage(arg) { return nil, false }
+		if v[i], ok = fraction(arg, 100); !ok { return }
+		v[i] /= 100
+	}
+	return
+}
+
+func parseHSL(args []string, alpha float64, legacy bool) (c Color, ok bool) {
+	h, ok := hue(args[0])
+	if !ok { return }
+	sl, ok := percentages(args[1:], legacy)
+	if !ok { return }
+	r, g, b := hslToRGB(h, sl[0], sl[1])
+	return Color{r, g, b, alpha}, true
+}
+
+func parseHWB(args []string, alpha float64) (c Color, ok bool) {
+	h, ok := hue(args[0])
+	if !ok { return }
+	wb, ok := percentages(args[1:], false)
+	if !ok { return }
+	w, b := wb[0], wb[1]
+	if w + b >= 1 {
+		gray := w / (w + b) * 255
+		return Color{gray, gray, gray, alpha}, true
+	}
+	c = Color{A: alpha}
+	c.R, c.G, c.B = hslToRGB(h, 1, 0.5)
+	scale := func(v float64) float64 { return v * (1 - w - b) + w * 255 }
+	c.R, c.G, c.B = scale(c.R), scale(c.G), scale(c.B)
+	return c, true
+}
+
+// as in CSS Color Module Level 4, components from 0 to 255
+func hslToRGB(h, s, l float64) (r, g, b float64) {
+	a := s * math.Fmin(l, 1 - l)
+	f := func(n float64) float64 {
+		k := math.Fmod(n + h / 30, 12)
+		return (l - a * math.Fmax(-1, math.Fmin(math.Fmin(k - 3, 9 - k), 1))) * 255
+	}
+	return f(0), f(8), f(4)
+}
+
+// the whole number v is close to, ok is false when there is none
+func whole(v float64) (n uint32, ok bool) {
+	r := math.Floor(v + 0.5)
+	return uint32(r), math.Fabs(v - r) < EPSILON
+}
+
+// #rrggbb, or #rgb when that's the same
+func hex(v uint32, digits int) string {
+	s := fmt.Sprintf("%0*x", digits, v)
+	for i := 0; i < len(s); i += 2 {
+		if s[i] != s[i+1] { return "#" + s }
+	}
+	short := make([]byte, len(s) / 2)
+	for i := range short {
+		short[i] = s[i*2]
+	}
+	return "#" + string(short)
+}
+
+// #rrggbb or #rgb, ok is false when c isn't opaque or has components that
+// aren't whole numbers
+func (c Color) Hex() (s string, ok bool) {
+	r, okR := whole(c.R)
+	g, okG := whole(c.G)
+	b, okB := whole(c.B)
+	if !okR || !okG || !okB || c.A != 1 { return }
+	return hex(r << 16 | g << 8 | b, 6), true
+}
+
+// Shortest way of writing c, ok is false when there is none that is exactly
+// the same color. Colors that aren't opaque are only written in hex when
+// hexAlpha is set, older browsers don't know #rgba.
+func (c Color) Shortest(hexAlpha bool) (s string, ok bool) {
+	r, okR := whole(c.R)
+	g, okG := whole(c.G)
+	b, okB := whole(c.B)
+	a, okA := whole(c.A * 255)
+	if !okR || !okG || !okB { return }
+	v := r << 16 | g << 8 | b
+
+	var candidates []string
+	switch {
+	case okA && a == 255:
+		candidates = append(candidates, hex(v, 6))
+		if name, found := shortNames[v]; found { candidates = append(candidates, name) }
+	case okA && hexAlpha:
+		candidates = append(candidates, hex(v << 8 | a, 8))
+	}
+	if v == 0 && c.A == 0 { candidates = append(candidates, "transparent") }
+
+	// hex wins ties
+	for _, candidate := range candidates {
+		if !ok || len(candidate) < len(s) { s, ok = candidate, true }
+	}
+	return
+}
+
+// The shortest form of the color s, ok is false when s isn't a color or
+// nothing shorter has exactly the same color
+func Shorten(s string, hexAlpha bool) (short string, ok bool) {
+	c, ok := Parse(s)
+	if !ok { return }
+	return c.Shortest(hexAlpha)
+}
@@ -0,0 +1,129 @@
+package color
+
+import (
+	"testing"
+)
+
+var parseTests = []struct {
+	in string
+	c  Color
+	ok bool
+}{
+	{"#f00", Color{255, 0, 0, 1}, true},
+	{"#F00A", Color{255, 0, 0, 170.0 / 255}, true},
+	{"#0000ff", Color{0, 0, 255, 1}, true},
+	{"#0000ff80", Color{0, 0, 255, 128.0 / 255}, true},
+	{"#12345", Color{}, false},
+	{"#ggg", Color{}, false},
+	{"Red", Color{255, 0, 0, 1}, true},
+	{"transparent", Color{0, 0, 0, 0}, true},
+	{"currentColor", Color{}, false},
+	{"inherit", Color{}, false},
+	{"rgb(255, 0, 0)", Color{255, 0, 0, 1}, true},
+	{"rgb(100%, 0%, 50%)", Color{255, 0, 127.5, 1}, true},
+	{"rgb(100%, 0, 0)", Color{}, false},
+	{"rgb(300, -20, 0.5)", Color{255, 0, 0.5, 1}, true},
+	{"rgba(0, 0, 0, .5)", Color{0, 0, 0, 0.5}, true},
+	{"rgba(0,0,0,50%)", Color{0, 0, 0, 0.5}, true},
+	{"rgb(0 0 0 / 1)", Color{0, 0, 0, 1}, true},
+	{"rgb(0 0 0 /)", Color{}, false},
+	{"rgb(0, 0 0)", Color{}, false},
+	{"rgb(0, 0, 0 / 1)", Color{}, false},
+	{"rgb(var(--x))", Color{}, false},
+	{"hsl(120, 100%, 50%)", Color{0, 255, 0, 1}, true},
+	{"hsl(120 100% 50%)", Color{0, 255, 0, 1}, true},
+	{"hsl(-240deg 100% 50%)", Color{0, 255, 0, 1}, true},
+	{"hsl(0.5turn, 100%, 50%)", Color{0, 255, 255, 1}, true},
+	{"hsl(120, 100, 50)", Color{}, false},
+	{"hsla(0, 0%, 100%, 0)", Color{255, 255, 255, 0}, true},
+	{"hwb(0 0% 0%)", Color{255, 0, 0, 1}, true},
+	{"hwb(0 60% 60%)", Color{127.5, 127.5, 127.5, 1}, true},
+	{"hwb(0, 0%, 0%)", Color{}, false},
+	{"lab(50% 40 59)", Color{}, false},
+	{"color(srgb 1 0 0)", Color{}, false},
+}
+
+func near(a, b Color) bool {
+	for _, d := range []float64{a.R - b.R, a.G - b.G, a.B - b.B, a.A - b.A} {
+		if d > EPSILON || d < -EPSILON { return false }
+	}
+	return true
+}
+
+func TestParse(t *testing.T) {
+	for _, test := range parseTests {
+		c, ok := Parse(test.in)
+		if ok != test.ok || ok && !near(c, test.c) { t.Errorf("%q: %v %v, want %v %v", test.in, c, ok, test.c, test.ok) }
+	}
+}
+
+var shortenTests = []struct {
+	in       string
+	hexAlpha bool
+	out      string
+	ok       bool
+}{
+	{"#f00", false, "red", true},
+	{"#ff0000", false, "red", true},
+	{"white", false, "#fff", true},
+	{"#FFFFFF", false, "#fff", true},
+	{"#aabbcc", false, "#abc", true},
+	{"#aabbcd", false, "#aabbcd", true},
+	// hex wins ties
+	{"#00f", false, "#00f", true},
+	{"rgb(0, 0, 255)", false, "#00f", true},
+	{"rgba(0,0,0,1)", false, "#000", true},
+	{"#aabbccdd", true, "#abcd", true},
+	{"#aabbccdd", false, "", false},
+	{"rgba(255, 0, 0, .2)", true, "#f003", true},
+	{"rgba(255, 0, 0, .3)", true, "", false},
+	{"rgba(0, 0, 0, 0)", false, "transparent", true},
+	{"#0000", true, "#0000", true},
+	{"hsl(0, 100%, 50%)", false, "red", true},
+	{"hsl(0, 0%, 50%)", false, "", false},
+	{"rgb(100%, 0%, 50%)", false, "", false},
+	{"lightgoldenrodyellow", false, "#fafad2", true},
+	{"currentColor", false, "", false},
+	{"lch(50% 40 59)", false, "", false},
+}
+
+func TestShorten(t *testing.T) {
+	for _, test := range shortenTests {
+		out, ok := Shorten(test.in, test.hexAlpha)
+		if out != test.out || ok != test.ok { t.Errorf("%q, hex alpha %v: %q %v, want %q %v", test.in, test.hexAlpha, out, ok, test.out, test.ok) }
+	}
+}
+
+func TestShortNames(t *testing.T) {
+	for v, name := range shortNames {
+		if NAMES[name] != v { t.Errorf("%s isn't %06x", name, v) }
+		if s, _ := rgb(v).Shortest(false); len(s) > len(name) { t.Errorf("%s written as %s", name, s) }
+	}
+}
+
+func TestIsProperty(t *testing.T) {
+	for _, test := range []struct{ property string; ok bool }{
+		{"color", true},
+		{"background-color", true},
+		{"-webkit-text-fill-color", true},
+		{"box-shadow", true},
+		{"background", true},
+		{"border-left", true},
+		{"font-family", false},
+		{"animation-name", false},
+		{"-moz-transition", false},
+	} {
+		if IsProperty(test.property) != test.ok { t.Errorf("%s: %v", test.property, !test.ok) }
+	}
+}
+
+func TestHex(t *testing.T) {
+	for _, test := range []struct{ c Color; hex string; ok bool }{
+		{Color{255, 0, 0, 1}, "#f00", true},
+		{Color{1, 2, 3, 1}, "#010203", true},
+		{Color{255, 255, 255, 0.5}, "", false},
+		{Color{127.5, 0, 0, 1}, "", false},
+	} {
+		if hex, ok := test.c.Hex(); hex != test.hex || ok != test.ok { t.Errorf("%v: %q %v", test.c, hex, ok) }
+	}
+}
@@ -0,0 +1,79 @@
+// Positioned warnings and errors
+package diag
+
+import (
+	"./lexer"
+	"bytes"
+	"fmt"
+	"io"
+	"utf8"
+)
+
+type Reporter struct {
+	Source   *lexer.Source
+	Out      io.Writer
+	Warnings int
+	Errors   int
+}
+
+func CreateReporter(src *lexer.Source, out io.Writer) (r *Reporter) {
+	r = &Reporter{Source: src, Out: out}
+	return
+}
+
+func (r *Reporter) Warning(tv lexer.TokenValue, format string, args ...interface{}) {
+	r.Warnings++
+	r.report(tv, "warning: " + fmt.Sprintf(format, args...))
+}
+
+func (r *Reporter) Error(tv lexer.TokenValue, format string, args ...interface{}) {
+	r.Errors++
+	r.report(tv, "error: " + fmt.Sprintf(format, args...))
+}
+
+// file.css:123:45: message, followed by the line with the token underlined
+func (r *Reporter) report(tv lexer.TokenValue, msg string) {
+	var b bytes.Buffer
+	name, line := "", tv.Pos.Line
+	if r.Source != nil { name, line = r.Source.Locate(line) }
+	if name == "" { name = "<stdin>" }
+	fmt.Fprintf(&b, "%s:%d:%d: %s\n", name, line, tv.Pos.Column, msg)
+	if r.Source != nil {
+		text := r.Source.Line(tv.Pos.Line)
+		if len(text) > 0 {
+			b.WriteString(text)
+			b.WriteByte('\n')
+			b.WriteString(caret(text, tv.Pos.Column, tv.Value))
+			b.WriteByte('\n')
+		}
+	}
+	// single write so reports from concurrent files don't interleave
+	r.Out.Write(b.Bytes())
+}
+
+// ^^^ under the token, tabs are kept so the carets line up with the source
+func caret(line string, column int, value string) string {
+	var b bytes.Buffer
+	i := 1
+	for _, c := range line {
+		if i >= column { break }
+		if c == '\t' {
+			b.WriteByte('\t')
+		} else {
+			b.WriteByte(' ')
+		}
+		i++
+	}
+	n := 0
+	for _, c := range value {
+		if c == '\n' { break }
+		n++
+	}
+	// don't run past the end of the line
+	if rest := utf8.RuneCountInString(line) - column + 1; n > rest { n = rest }
+	if n < 1 { n = 1 }
+	for ; n > 0; n-- {
+		b.WriteByte('^')
+	}
+	return b.String()
+}
@@ -0,0 +1,78 @@
+package diag
+
+import (
+	"./lexer"
+	"bytes"
+	"testing"
+)
+
+var reportTests = []struct {
+	name, in string
+	token    int
+	out      string
+}{
+	{"", "a{color:red}", 2, "<stdin>:1:3: warning: x\na{color:red}\n  ^^^^^\n"},
+	{"a.css", "a{\n  b:c}", 3, "a.css:2:3: warning: x\n  b:c}\n  ^\n"},
+	// tabs are kept so the carets line up
+	{"a.css", "a{\n\tcolor:é}", 5, "a.css:2:8: warning: x\n\tcolor:é}\n\t      ^\n"},
+	// a token running past the line is underlined to its end
+	{"a.css", "a/* x\ny */b", 1, "a.css:1:2: warning: x\na/* x\n ^^^^\n"},
+}
+
+// tokens of s, recorded in src
+func tokenize(s string, src *lexer.Source) (tokens []lexer.TokenValue) {
+	in := make(chan(int))
+	lex, out := lexer.CreateLexer(in)
+	lex.Source = src
+	go func() {
+		for _, c := range s {
+			in <- c
+		}
+		in <- -1
+	}()
+	go lex.Run()
+	for tv := <- out; tv.Token != lexer.EndToken; tv = <- out {
+		tokens = append(tokens, tv)
+	}
+	return
+}
+
+func TestReport(t *testing.T) {
+	for _, test := range reportTests {
+		src := lexer.CreateSource(test.name)
+		tokens := tokenize(test.in, src)
+		var out bytes.Buffer
+		r := CreateReporter(src, &out)
+		r.Warning(tokens[test.token], "x")
+		if out.String() != test.out {
+			t.Errorf("%q: report is\n%s\nwant\n%s", test.in, out.String(), test.out)
+		}
+		if r.Warnings != 1 || r.Errors != 0 {
+			t.Errorf("%q: %d warnings and %d errors", test.in, r.Warnings, r.Errors)
+		}
+	}
+}
+
+func TestError(t *testing.T) {
+	var out bytes.Buffer
+	r := CreateReporter(nil, &out)
+	r.Error(lexer.TokenValue{Token: lexer.Identifier, Value: "a", Pos: lexer.Position{4, 2, 3}}, "bad %s", "a")
+	if out.String() != "<stdin>:2:3: error: bad a\n" || r.Errors != 1 {
+		t.Errorf("report is %q, %d errors", out.String(), r.Errors)
+	}
+}
+
+// positions in a concatenation refer to the file they are in
+func TestReportParts(t *testing.T) {
+	src := lexer.CreateSource("site")
+	src.Parts = []lexer.Part{{"a.css", 1}, {"b.css", 3}}
+	var tv lexer.TokenValue
+	for _, tv = range tokenize("a{}\n\nb{\n  c d", src) {
+		if tv.Value == "d" { break }
+	}
+	var out bytes.Buffer
+	CreateReporter(src, &out).Warning(tv, "x")
+	if want := "b.css:2:5: warning: x\n  c d\n    ^\n"; out.String() != want {
+		t.Errorf("report is %q, want %q", out.String(), want)
+	}
+}
@@ -1,193 +1,470 @@
-// Simple streaming lexer for CSS
+// Streaming lexer for CSS, tokenizes according to CSS Syntax Level 3
 package lexer
 
-import "bytes"
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"utf8"
+)
 
-type HandlerFn func(*Lexer, int)
+const eof = -1
+
+// size of the token channels between the goroutines of the concurrent mode
+const BUFFER = 256
 
 type TokenValue struct {
 	Token Token
 	Value string
+	// unit of a Dimension, Value still holds the complete source text
+	Unit  string
+	Pos   Position
+}
+
+// code point in the lookahead buffer, with the byte offset it was read at
+type char struct {
+	c      int
+	offset int
+}
+
+// Anything tokens can be pulled from, a Lexer or the output of a stage
+type TokenReader interface {
+	Next() TokenValue
+}
+
+// Tokens sent by a stage running in a goroutine of its own
+type Channel chan(TokenValue)
+
+func (c Channel) Next() TokenValue {
+	return <- c
 }
 
 type Lexer struct {
-	In chan(int)
-	Out chan(TokenValue)
-	token bytes.Buffer
-	prev int
-	lastToken string
-	handler HandlerFn
+	// code points are read from Reader when it is set, otherwise from In
+	Reader io.RuneReader
+	In     chan(int)
+	Out    chan(TokenValue)
+	// first error reading the input, which ends it
+	Err    os.Error
+	// when set, the input is recorded there for diagnostics
+	Source *Source
+	token  bytes.Buffer
+	unit   string
+	start  Position
+	ahead  []char
+	offset int
+	line   int
+	column int
+	done   bool
 }
 
 func CreateLexer(in chan(int)) (lex *Lexer, out chan(TokenValue)) {
-	out = make(chan(TokenValue))
+	out = make(chan(TokenValue), BUFFER)
 	lex = &Lexer{In: in, Out: out}
 	return
 }
 
+// Lexer pulling its input from r, tokens are read with Next or sent to Out
+// by Run
+func CreateReader(r io.Reader) (lex *Lexer) {
+	rr, ok := r.(io.RuneReader)
+	if !ok { rr = bufio.NewReader(r) }
+	lex = &Lexer{Reader: rr, Out: make(chan(TokenValue), BUFFER)}
+	return
+}
+
 func isDigit(c int) bool {
 	return c >= '0' && c <= '9'
 }
 
+func isHexDigit(c int) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func isNameStart(c int) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+}
+
 func isNameChar(c int) bool {
-	return c == '_' || c == '-' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+	return isNameStart(c) || isDigit(c) || c == '-'
 }
 
-func (lex *Lexer) whitespace(c int) {
+func isWhitespace(c int) bool {
+	return c == ' ' || c == '\t' || c == '\n'
+}
+
+func isNonPrintable(c int) bool {
+	return (c >= 0 && c <= 8) || c == 0xb || (c >= 0xe && c <= 0x1f) || c == 0x7f
+}
+
+func isValidEscape(a, b int) bool {
+	return a == '\\' && b != '\n'
+}
+
+func startsIdentifier(a, b, c int) bool {
 	switch {
-	case c == ' ' || c == '\t' || c == '\n':
-		lex.token.WriteRune(c)
-		return
-	default:
-		lex.next(Whitespace)
-		lex.token.Reset()
-		lex.handler = nil
-		lex.tokenize(c)
+	case a == '-':
+		return isNameStart(b) || b == '-' || isValidEscape(b, c)
+	case a == '\\':
+		return isValidEscape(a, b)
 	}
+	return isNameStart(a)
 }
 
-func (lex *Lexer) comment(c int) {
+func startsNumber(a, b, c int) bool {
 	switch {
-	case lex.token.Len() == 1 && c != '*':
-		lex.next(Op)
-		lex.token.Reset()
-		lex.token.WriteRune(c)
-		lex.handler = nil
+	case a == '+' || a == '-':
+		return isDigit(b) || (b == '.' && isDigit(c))
+	case a == '.':
+		return isDigit(b)
+	}
+	return isDigit(a)
+}
+
+func (lex *Lexer) pull() int {
+	if lex.done { return eof }
+	if lex.Reader != nil {
+		c, size, err := lex.Reader.ReadRune()
+		if err != nil {
+			if err != os.EOF { lex.Err = err }
+			lex.done = true
+			return eof
+		}
+		lex.offset += size
+		return c
+	}
+	c := <- lex.In
+	if c == eof {
+		lex.done = true
+	} else {
+		lex.offset += utf8.RuneLen(c)
+	}
+	return c
+}
+
+// read the next code point into the lookahead buffer, normalizing newlines
+// and NULs the way the spec preprocesses its input
+func (lex *Lexer) fill() {
+	offset := lex.offset
+	c := lex.pull()
+	for c == '\r' {
+		lex.ahead = append(lex.ahead, char{'\n', offset})
+		// CRLF is a single newline
+		if c = lex.pull(); c == '\n' { return }
+		offset = lex.offset
+	}
+	switch c {
+	case '\f':
+		c = '\n'
+	case 0:
+		c = 0xfffd
+	}
+	lex.ahead = append(lex.ahead, char{c, offset})
+}
+
+func (lex *Lexer) peek(i int) int {
+	for len(lex.ahead) <= i {
+		lex.fill()
+	}
+	return lex.ahead[i].c
+}
+
+// position of the next code point
+func (lex *Lexer) position() Position {
+	lex.peek(0)
+	return Position{lex.ahead[0].offset, lex.line + 1, lex.column + 1}
+}
+
+func (lex *Lexer) consume() (c int) {
+	c = lex.peek(0)
+	lex.ahead = lex.ahead[1:]
+	if c == eof { return }
+	lex.token.WriteRune(c)
+	if c == '\n' {
+		lex.line++
+		lex.column = 0
+	} else {
+		lex.column++
+	}
+	return
+}
+
+func (lex *Lexer) consumeComment() Token {
+	lex.consume()
+	lex.consume()
+	for {
+		switch lex.consume() {
+		case eof:
+			return Comment
+		case '*':
+			if lex.peek(0) == '/' {
+				lex.consume()
+				return Comment
+			}
+		}
+	}
+	panic("unreachable")
+}
+
+func (lex *Lexer) consumeString() Token {
+	q := lex.consume()
+	for {
+		switch c := lex.peek(0); c {
+		case eof:
+			return String
+		case '\n':
+			// unescaped newline, leave it for the next token
+			return BadString
+		case '\\':
+			lex.consume()
+			// escaped quote, backslash or newline
+			lex.consume()
+		default:
+			lex.consume()
+			if c == q { return String }
+		}
+	}
+	panic("unreachable")
+}
+
+func (lex *Lexer) consumeEscape() {
+	lex.consume()
+	if !isHexDigit(lex.peek(0)) {
+		lex.consume()
 		return
-	case c == '/' && lex.prev == '*':
-		lex.token.WriteRune(c)
-		lex.next(Comment)
-		lex.token.Reset()
-		lex.handler = nil
-	default:
-		lex.token.WriteRune(c)
 	}
+	for i := 0; i < 6 && isHexDigit(lex.peek(0)); i++ {
+		lex.consume()
+	}
+	if isWhitespace(lex.peek(0)) { lex.consume() }
 }
 
-func (lex *Lexer) str(c int) {
-	f, _, _ := lex.token.ReadRune()
-	lex.token.UnreadRune()
-	switch {
-	case lex.token.Len() == 0 || lex.prev == '\\':
-		lex.token.WriteRune(c)
-	case c != f:
-		lex.token.WriteRune(c)
-	default:
-		lex.token.WriteRune(c)
-		lex.next(String)
-		lex.token.Reset()
-		lex.handler = nil
+func (lex *Lexer) consumeName() {
+	for {
+		c := lex.peek(0)
+		switch {
+		case isNameChar(c):
+			lex.consume()
+		case isValidEscape(c, lex.peek(1)):
+			lex.consumeEscape()
+		default:
+			return
+		}
 	}
 }
 
-func (lex *Lexer) identifier(c int) {
-	switch {
-	case isNameChar(c) || isDigit(c):
-		lex.token.WriteRune(c)
-	default:
-		lex.next(Identifier)
-		lex.token.Reset()
-		lex.handler = nil
-		lex.tokenize(c)
+func (lex *Lexer) consumeDigits() {
+	for isDigit(lex.peek(0)) {
+		lex.consume()
 	}
 }
 
-func (lex *Lexer) number(c int) {
-	nondigit := !isDigit(c)
-	point := '.' == lex.prev
-	switch {
-	// .2em or .classname ?
-	case point && nondigit:
-		lex.next(Period)
-		lex.token.Reset()
-		lex.handler = nil
-		lex.tokenize(c)
-	// -2px or -moz-something
-	case '-' == lex.prev && nondigit && c != '.':
-		lex.handler = (*Lexer).identifier
-		lex.handler(lex, c)
-	case !nondigit || (!point && ('.' == c || '-' == c)):
-		lex.token.WriteRune(c)
-	case lex.lastToken == "#":
-		lex.handler = (*Lexer).identifier
-		lex.handler(lex, c)
-	default:
-		lex.next(Number)
-		lex.token.Reset()
-		lex.handler = nil
-		lex.tokenize(c)
-	}
-}
-
-func (lex *Lexer) operator(c int) {
+func (lex *Lexer) consumeNumber() {
+	if c := lex.peek(0); c == '+' || c == '-' { lex.consume() }
+	lex.consumeDigits()
+	if lex.peek(0) == '.' && isDigit(lex.peek(1)) {
+		lex.consume()
+		lex.consumeDigits()
+	}
+	if c := lex.peek(0); c == 'e' || c == 'E' {
+		d := lex.peek(1)
+		if isDigit(d) || ((d == '+' || d == '-') && isDigit(lex.peek(2))) {
+			lex.consume()
+			lex.consume()
+			lex.consumeDigits()
+		}
+	}
+}
+
+// number, followed by a unit or a percent sign
+func (lex *Lexer) consumeNumeric() Token {
+	lex.consumeNumber()
 	switch {
-	case '=' == c:
-		lex.token.WriteRune(c)
-		lex.next(Match)
-		lex.token.Reset()
-		lex.handler = nil
-	case lex.token.Len() == 0:
-		lex.token.WriteRune(c)
-	default:
-		lex.next(TokenMap[lex.prev])
-		lex.token.Reset()
-		lex.handler = nil
-		lex.tokenize(c)
+	case startsIdentifier(lex.peek(0), lex.peek(1), lex.peek(2)):
+		n := lex.token.Len()
+		lex.consumeName()
+		lex.unit = lex.token.String()[n:]
+		return Dimension
+	case lex.peek(0) == '%':
+		lex.consume()
+		return Percentage
+	}
+	return Number
+}
+
+// identifier, function or unquoted url
+func (lex *Lexer) consumeIdentLike() Token {
+	lex.consumeName()
+	if lex.peek(0) != '(' { return Identifier }
+	name := lex.token.String()
+	lex.consume()
+	if strings.ToLower(name) != "url" { return Function }
+	for isWhitespace(lex.peek(0)) && isWhitespace(lex.peek(1)) {
+		lex.consume()
+	}
+	// whitespace after the paren isn't part of the token value
+	lex.token.Truncate(len(name) + 1)
+	c, d := lex.peek(0), lex.peek(1)
+	if c == '"' || c == '\'' || (isWhitespace(c) && (d == '"' || d == '\'')) {
+		return Function
 	}
+	return lex.consumeURL()
 }
 
-func (lex *Lexer) next(t Token) {
-	value := lex.token.String()
-	lex.Out <- TokenValue{t, value}
-	lex.lastToken = value
+func (lex *Lexer) consumeURL() Token {
+	n := lex.token.Len()
+	for isWhitespace(lex.peek(0)) {
+		lex.consume()
+	}
+	lex.token.Truncate(n)
+	for {
+		c := lex.peek(0)
+		switch {
+		case c == ')' || c == eof:
+			lex.consume()
+			return URL
+		case isWhitespace(c):
+			n = lex.token.Len()
+			for isWhitespace(lex.peek(0)) {
+				lex.consume()
+			}
+			if c = lex.peek(0); c != ')' && c != eof {
+				return lex.consumeBadURL()
+			}
+			lex.token.Truncate(n)
+		case c == '"' || c == '\'' || c == '(' || isNonPrintable(c):
+			return lex.consumeBadURL()
+		case c == '\\':
+			if !isValidEscape(c, lex.peek(1)) {
+				return lex.consumeBadURL()
+			}
+			lex.consumeEscape()
+		default:
+			lex.consume()
+		}
+	}
+	panic("unreachable")
+}
+
+// skip to the end of a broken url so the rest of the input can be recovered
+func (lex *Lexer) consumeBadURL() Token {
+	for {
+		c := lex.peek(0)
+		switch {
+		case c == eof:
+			return BadURL
+		case isValidEscape(c, lex.peek(1)):
+			lex.consumeEscape()
+		default:
+			lex.consume()
+			if c == ')' { return BadURL }
+		}
+	}
+	panic("unreachable")
 }
 
-func (lex *Lexer) handlerForToken(t Token) (fn HandlerFn) {
-	switch t {
-	case Whitespace:
-		return (*Lexer).whitespace
-	case Comment:
-		return (*Lexer).comment
-	case String:
-		return (*Lexer).str
-	case Identifier:
-		return (*Lexer).identifier
-	case Number:
-		return (*Lexer).number
+// U+26, U+0-7F, U+4??
+func (lex *Lexer) consumeUnicodeRange() Token {
+	lex.consume()
+	lex.consume()
+	i := 0
+	for ; i < 6 && isHexDigit(lex.peek(0)); i++ {
+		lex.consume()
+	}
+	wildcard := false
+	for ; i < 6 && lex.peek(0) == '?'; i++ {
+		lex.consume()
+		wildcard = true
+	}
+	if !wildcard && lex.peek(0) == '-' && isHexDigit(lex.peek(1)) {
+		lex.consume()
+		for i = 0; i < 6 && isHexDigit(lex.peek(0)); i++ {
+			lex.consume()
+		}
 	}
-	return (*Lexer).operator
+	return UnicodeRange
 }
 
-func (lex *Lexer) tokenize(c int) {
-	if lex.handler == nil {
-		token := TokenMap[c]
-		lex.handler = lex.handlerForToken(token)
+// A single code point, ~= || and the like are two delims as in the spec
+func (lex *Lexer) consumeDelim() Token {
+	c := lex.consume()
+	if t, ok := TokenMap[c]; ok {
+		return t
 	}
-	lex.handler(lex, c)
-	lex.prev = c
+	return Delim
 }
 
-func (lex *Lexer) end() {
+func (lex *Lexer) consumeToken() Token {
+	c, d, e := lex.peek(0), lex.peek(1), lex.peek(2)
 	switch {
-	// finish the current token
-	case lex.handler != nil:
-		lex.handler(lex, -1)
-	// still something in buffer, assuming whitespace
-	case lex.token.Len() > 0:
-		lex.next(Whitespace)
+	case c == eof:
+		return EndToken
+	case c == '/' && d == '*':
+		return lex.consumeComment()
+	case isWhitespace(c):
+		for isWhitespace(lex.peek(0)) {
+			lex.consume()
+		}
+		return Whitespace
+	case c == '"' || c == '\'':
+		return lex.consumeString()
+	case c == '#' && (isNameChar(d) || isValidEscape(d, e)):
+		lex.consume()
+		lex.consumeName()
+		return Hash
+	case startsNumber(c, d, e):
+		return lex.consumeNumeric()
+	case c == '-' && d == '-' && e == '>':
+		lex.consume()
+		lex.consume()
+		lex.consume()
+		return CDC
+	case c == '<' && d == '!' && e == '-' && lex.peek(3) == '-':
+		for i := 0; i < 4; i++ {
+			lex.consume()
+		}
+		return CDO
+	case c == '@' && startsIdentifier(d, e, lex.peek(3)):
+		lex.consume()
+		lex.consumeName()
+		return AtKeyword
+	case (c == 'u' || c == 'U') && d == '+' && (isHexDigit(e) || e == '?'):
+		return lex.consumeUnicodeRange()
+	case startsIdentifier(c, d, e):
+		return lex.consumeIdentLike()
 	}
-	lex.Out <- TokenValue{EndToken, ""}
+	return lex.consumeDelim()
+}
+
+// Reads the next token, at the end of the input that is the EndToken
+func (lex *Lexer) Next() (tv TokenValue) {
+	lex.start = lex.position()
+	t := lex.consumeToken()
+	tv = TokenValue{Token: t, Value: lex.token.String(), Unit: lex.unit, Pos: lex.start}
+	if lex.Source != nil { lex.Source.write(tv.Value) }
+	lex.token.Reset()
+	lex.unit = ""
+	return
 }
 
+// Sends the tokens to Out, for running in a goroutine of its own
 func (lex *Lexer) Run() {
-	var c int
 	for {
-		c = <- lex.In
-		if c == -1 {
-			lex.end()
-			return
-		} else {
-			lex.tokenize(c)
-		}
+		tv := lex.Next()
+		lex.Out <- tv
+		if tv.Token == EndToken { return }
+	}
+}
+
+// Tokens of a string, without the end token
+func Tokenize(s string) (tokens []TokenValue) {
+	lex := CreateReader(strings.NewReader(s))
+	for {
+		tv := lex.Next()
+		if tv.Token == EndToken { return }
+		tokens = append(tokens, tv)
 	}
+	panic("unreachable")
 }
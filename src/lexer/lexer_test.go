@@ -0,0 +1,83 @@
+package lexer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+type token struct {
+	t Token
+	v string
+}
+
+var tokenTests = []struct {
+	in     string
+	tokens []token
+}{
+	{" a  b", []token{{Whitespace, " "}, {Identifier, "a"}, {Whitespace, "  "}, {Identifier, "b"}}},
+	{"/* x */a", []token{{Comment, "/* x */"}, {Identifier, "a"}}},
+	{`"a\"b"`, []token{{String, `"a\"b"`}}},
+	{"'a\nb", []token{{BadString, "'a"}, {Whitespace, "\n"}, {Identifier, "b"}}},
+	{"foo(", []token{{Function, "foo("}}},
+	{"url(a.png)", []token{{URL, "url(a.png)"}}},
+	{`url( "a" )`, []token{{Function, "url("}, {Whitespace, " "}, {String, `"a"`}, {Whitespace, " "}, {RightParen, ")"}}},
+	{"url(a b)", []token{{BadURL, "url(a b)"}}},
+	{"@media", []token{{AtKeyword, "@media"}}},
+	{"#fff #1a", []token{{Hash, "#fff"}, {Whitespace, " "}, {Hash, "#1a"}}},
+	{"+.5 1e3 -1", []token{{Number, "+.5"}, {Whitespace, " "}, {Number, "1e3"}, {Whitespace, " "}, {Number, "-1"}}},
+	{"50%", []token{{Percentage, "50%"}}},
+	{"1.5E2em", []token{{Dimension, "1.5E2em"}}},
+	{".5.5", []token{{Number, ".5"}, {Number, ".5"}}},
+	{"12.", []token{{Number, "12"}, {Period, "."}}},
+	{"1-", []token{{Number, "1"}, {Delim, "-"}}},
+	{"U+0-7F u+4??", []token{{UnicodeRange, "U+0-7F"}, {Whitespace, " "}, {UnicodeRange, "u+4??"}}},
+	{"<!---->", []token{{CDO, "<!--"}, {CDC, "-->"}}},
+	{`\31 23`, []token{{Identifier, `\31 23`}}},
+	{"-x --x -", []token{{Identifier, "-x"}, {Whitespace, " "}, {Identifier, "--x"}, {Whitespace, " "}, {Delim, "-"}}},
+	{"a:b;", []token{{Identifier, "a"}, {Colon, ":"}, {Identifier, "b"}, {Semicolon, ";"}}},
+	{"!important", []token{{Bang, "!"}, {Identifier, "important"}}},
+	// the spec has no match or column tokens, these are two delims each
+	{"a~=b", []token{{Identifier, "a"}, {Tildae, "~"}, {Match, "="}, {Identifier, "b"}}},
+	{"a|=b", []token{{Identifier, "a"}, {Pipe, "|"}, {Match, "="}, {Identifier, "b"}}},
+	{"a||b", []token{{Identifier, "a"}, {Pipe, "|"}, {Pipe, "|"}, {Identifier, "b"}}},
+	{"a^=b$=c*=d", []token{{Identifier, "a"}, {Carrot, "^"}, {Match, "="}, {Identifier, "b"},
+		{Dollar, "$"}, {Match, "="}, {Identifier, "c"}, {Star, "*"}, {Match, "="}, {Identifier, "d"}}},
+}
+
+func TestTokenize(t *testing.T) {
+	for _, test := range tokenTests {
+		tokens := Tokenize(test.in)
+		if len(tokens) != len(test.tokens) {
+			t.Errorf("%q: %d tokens, want %d: %v", test.in, len(tokens), len(test.tokens), tokens)
+			continue
+		}
+		for i, tv := range tokens {
+			if tv.Token != test.tokens[i].t || tv.Value != test.tokens[i].v {
+				t.Errorf("%q: token %d is %v %q, want %v %q", test.in, i, tv.Token, tv.Value, test.tokens[i].t, test.tokens[i].v)
+			}
+		}
+	}
+}
+
+func TestDimensionUnit(t *testing.T) {
+	for _, test := range []struct{ in, unit string }{{"10px", "px"}, {"1.5E2em", "em"}, {"2\\70x", "\\70x"}} {
+		tokens := Tokenize(test.in)
+		if len(tokens) != 1 || tokens[0].Token != Dimension || tokens[0].Unit != test.unit {
+			t.Errorf("%q: %v, want a dimension in %q", test.in, tokens, test.unit)
+		}
+	}
+}
+
+// the largest stylesheet of testdata, BenchmarkPipeline in main runs the
+// whole corpus
+func BenchmarkLexer(b *testing.B) {
+	css, err := ioutil.ReadFile("../testdata/rustdoc.css")
+	if err != nil { b.Fatal(err) }
+	b.SetBytes(int64(len(css)))
+	for i := 0; i < b.N; i++ {
+		lex := CreateReader(bytes.NewBuffer(css))
+		for lex.Next().Token != EndToken {
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package lexer
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Location of a token in the input. Offset is in bytes and starts at 0, line
+// and column start at 1 and the column counts code points.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+func (pos Position) String() string {
+	return fmt.Sprintf("%d:%d", pos.Line, pos.Column)
+}
+
+// File of a concatenated input
+type Part struct {
+	Name string
+	// line of the input the file starts on
+	Line int
+}
+
+// Text of the input read so far, kept so diagnostics can quote it. The
+// lexer may be writing while a later stage reads.
+type Source struct {
+	Name  string
+	// files the input is a concatenation of, in order
+	Parts []Part
+	lines []string
+	line  bytes.Buffer
+	lock  sync.Mutex
+}
+
+func CreateSource(name string) (src *Source) {
+	src = &Source{Name: name}
+	return
+}
+
+func (src *Source) write(s string) {
+	src.lock.Lock()
+	defer src.lock.Unlock()
+	for {
+		i := strings.Index(s, "\n")
+		if i < 0 { break }
+		src.line.WriteString(s[:i])
+		src.lines = append(src.lines, src.line.String())
+		src.line.Reset()
+		s = s[i+1:]
+	}
+	src.line.WriteString(s)
+}
+
+// Line n of the input, without the newline. The line currently being read
+// is returned as far as it has been read.
+func (src *Source) Line(n int) string {
+	src.lock.Lock()
+	defer src.lock.Unlock()
+	switch {
+	case n < 1 || n > len(src.lines) + 1:
+		return ""
+	case n == len(src.lines) + 1:
+		return src.line.String()
+	}
+	return src.lines[n-1]
+}
+
+// File and line in it of line n of the input
+func (src *Source) Locate(n int) (name string, line int) {
+	name, line = src.Name, n
+	for _, p := range src.Parts {
+		if p.Line > n { break }
+		name, line = p.Name, n - p.Line + 1
+	}
+	return
+}
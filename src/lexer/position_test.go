@@ -0,0 +1,87 @@
+package lexer
+
+import (
+	"strings"
+	"testing"
+)
+
+var positionTests = []struct {
+	in        string
+	positions []Position
+}{
+	{"a b", []Position{{0, 1, 1}, {1, 1, 2}, {2, 1, 3}}},
+	// the column counts code points, the offset bytes
+	{"a\n  éb c", []Position{{0, 1, 1}, {1, 1, 2}, {4, 2, 3}, {7, 2, 5}, {8, 2, 6}}},
+	{"x/*\n*/y", []Position{{0, 1, 1}, {1, 1, 2}, {6, 2, 3}}},
+	{"\"a\\\nb\" z", []Position{{0, 1, 1}, {6, 2, 3}, {7, 2, 4}}},
+	{"a\r\nb", []Position{{0, 1, 1}, {1, 1, 2}, {3, 2, 1}}},
+}
+
+func TestPositions(t *testing.T) {
+	for _, test := range positionTests {
+		tokens := Tokenize(test.in)
+		if len(tokens) != len(test.positions) {
+			t.Errorf("%q: %d tokens, want %d: %v", test.in, len(tokens), len(test.positions), tokens)
+			continue
+		}
+		for i, tv := range tokens {
+			if tv.Pos != test.positions[i] {
+				t.Errorf("%q: token %d %q at %+v, want %+v", test.in, i, tv.Value, tv.Pos, test.positions[i])
+			}
+		}
+	}
+}
+
+// lexes s in a goroutine of its own, recording it in src
+func run(s string, src *Source) chan(TokenValue) {
+	in := make(chan(int))
+	lex, out := CreateLexer(in)
+	lex.Source = src
+	go func() {
+		for _, c := range s {
+			in <- c
+		}
+		in <- eof
+	}()
+	go lex.Run()
+	return out
+}
+
+func TestSourceLine(t *testing.T) {
+	src := CreateSource("a.css")
+	out := run("a{\n\tb:c}\n\nd{", src)
+	for tv := <- out; tv.Token != EndToken; tv = <- out {
+	}
+	for n, line := range []string{"", "a{", "\tb:c}", "", "d{", ""} {
+		if s := src.Line(n); s != line {
+			t.Errorf("line %d is %q, want %q", n, s, line)
+		}
+	}
+}
+
+// a later stage may quote lines while the lexer is still writing them
+func TestSourceConcurrent(t *testing.T) {
+	src := CreateSource("")
+	for tv := range run(strings.Repeat("a{b:c}\n", 1000), src) {
+		if s := src.Line(tv.Pos.Line); !strings.HasPrefix("a{b:c}", s) {
+			t.Fatalf("line %d is %q", tv.Pos.Line, s)
+		}
+		if tv.Token == EndToken { break }
+	}
+}
+
+func TestLocate(t *testing.T) {
+	src := CreateSource("site")
+	src.Parts = []Part{{"a.css", 1}, {"b.css", 4}, {"c.css", 5}}
+	for _, test := range []struct {
+		n, line int
+		name    string
+	}{{1, 1, "a.css"}, {3, 3, "a.css"}, {4, 1, "b.css"}, {5, 1, "c.css"}, {9, 5, "c.css"}} {
+		if name, line := src.Locate(test.n); name != test.name || line != test.line {
+			t.Errorf("line %d is %s:%d, want %s:%d", test.n, name, line, test.name, test.line)
+		}
+	}
+	if name, line := CreateSource("x").Locate(3); name != "x" || line != 3 {
+		t.Errorf("line 3 without parts is %s:%d", name, line)
+	}
+}
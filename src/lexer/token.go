@@ -1,33 +1,41 @@
 package lexer
 
-// Token types
+// Token types, following CSS Syntax Level 3
 type Token int
 const (
 	_ = iota
 	Whitespace Token = iota
 	Comment
 	String
+	BadString
 	Identifier
-	Number
-	Op
-	// operator types
+	Function
+	AtKeyword
 	Hash
-	Percent
-	LeftParen
-	RightParen
-	Match
-	Child
-	At
+	Number
+	Percentage
+	Dimension
+	URL
+	BadURL
+	UnicodeRange
+	CDO
+	CDC
 	Colon
 	Semicolon
+	Comma
+	LeftParen
+	RightParen
 	LeftBrace
 	RightBrace
 	LeftBracket
 	RightBracket
+	// delimiters, Delim covers any code point without a type of its own
+	Delim
+	Match
+	Child
 	Plus
 	Star
 	Period
-	Comma
 	Tildae
 	Pipe
 	Backslash
@@ -46,28 +54,42 @@ func (b Token) String() string {
 		return "Comment"
 	case String:
 		return "String"
+	case BadString:
+		return "BadString"
 	case Identifier:
 		return "Identifier"
+	case Function:
+		return "Function"
+	case AtKeyword:
+		return "AtKeyword"
+	case Hash:
+		return "Hash"
 	case Number:
 		return "Number"
-	case Hash:
-		return "#"
-	case Percent:
-		return "%"
-	case LeftParen:
-		return "("
-	case RightParen:
-		return ")"
-	case Match:
-		return "="
-	case Child:
-		return ">"
-	case At:
-		return "@"
+	case Percentage:
+		return "Percentage"
+	case Dimension:
+		return "Dimension"
+	case URL:
+		return "URL"
+	case BadURL:
+		return "BadURL"
+	case UnicodeRange:
+		return "UnicodeRange"
+	case CDO:
+		return "<!--"
+	case CDC:
+		return "-->"
 	case Colon:
 		return ":"
 	case Semicolon:
 		return ";"
+	case Comma:
+		return ","
+	case LeftParen:
+		return "("
+	case RightParen:
+		return ")"
 	case LeftBrace:
 		return "{"
 	case RightBrace:
@@ -76,14 +98,18 @@ func (b Token) String() string {
 		return "["
 	case RightBracket:
 		return "]"
+	case Delim:
+		return "Delim"
+	case Match:
+		return "="
+	case Child:
+		return ">"
 	case Plus:
 		return "+"
 	case Star:
 		return "*"
 	case Period:
 		return "."
-	case Comma:
-		return ","
 	case Tildae:
 		return "~"
 	case Pipe:
@@ -100,6 +126,7 @@ func (b Token) String() string {
 	return "Unknown token"
 }
 
+// Single code point tokens, anything else not starting a longer token is a Delim
 var TokenMap = map[int] Token {
 	'{': LeftBrace,
 	'}': RightBrace,
@@ -107,92 +134,18 @@ var TokenMap = map[int] Token {
 	']': RightBracket,
 	'(': LeftParen,
 	')': RightParen,
-	'+': Plus,
-	'*': Star,
-	'=': Match,
 	',': Comma,
 	';': Semicolon,
 	':': Colon,
+	'=': Match,
+	'+': Plus,
+	'*': Star,
+	'.': Period,
 	'>': Child,
 	'~': Tildae,
 	'|': Pipe,
 	'\\': Backslash,
-	'%': Percent,
 	'$': Dollar,
-	'#': Hash,
-	'@': At,
 	'^': Carrot,
 	'!': Bang,
-	'/': Comment,
-	'_': Identifier,
-	'a': Identifier,
-	'b': Identifier,
-	'c': Identifier,
-	'd': Identifier,
-	'e': Identifier,
-	'f': Identifier,
-	'g': Identifier,
-	'h': Identifier,
-	'i': Identifier,
-	'j': Identifier,
-	'k': Identifier,
-	'l': Identifier,
-	'm': Identifier,
-	'n': Identifier,
-	'o': Identifier,
-	'p': Identifier,
-	'q': Identifier,
-	'r': Identifier,
-	's': Identifier,
-	't': Identifier,
-	'u': Identifier,
-	'v': Identifier,
-	'w': Identifier,
-	'x': Identifier,
-	'y': Identifier,
-	'z': Identifier,
-	'A': Identifier,
-	'B': Identifier,
-	'C': Identifier,
-	'D': Identifier,
-	'E': Identifier,
-	'F': Identifier,
-	'G': Identifier,
-	'H': Identifier,
-	'I': Identifier,
-	'J': Identifier,
-	'K': Identifier,
-	'L': Identifier,
-	'M': Identifier,
-	'N': Identifier,
-	'O': Identifier,
-	'P': Identifier,
-	'Q': Identifier,
-	'R': Identifier,
-	'S': Identifier,
-	'T': Identifier,
-	'U': Identifier,
-	'V': Identifier,
-	'W': Identifier,
-	'X': Identifier,
-	'Y': Identifier,
-	'Z': Identifier,
-	'-': Number,
-	'.': Number,
-	'0': Number,
-	'1': Number,
-	'2': Number,
-	'3': Number,
-	'4': Number,
-	'5': Number,
-	'6': Number,
-	'7': Number,
-	'8': Number,
-	'9': Number,
-	'"': String,
-	'\'': String,
-	' ': Whitespace,
-	'\t': Whitespace,
-	'\n': Whitespace,
 }
-
@@ -0,0 +1,103 @@
+// Skipping jobs whose sources and options haven't changed since they were built
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// What the last build of a job wrote. The key covers the version, the options
+// and the content of the sources, the outputs are recognized by their hashes.
+type CacheEntry struct {
+	Key         string
+	// hashes of the outputs by name
+	Outputs     map[string] string
+	// diagnostics of the build, repeated when the entry is used
+	Diagnostics []byte
+}
+
+func hashOf(data []byte) string {
+	h := sha1.New()
+	h.Write(data)
+	return fmt.Sprintf("%x", h.Sum())
+}
+
+// entries are found by the compressed output, every job has one of its own
+func entryName(job *Job) string {
+	return filepath.Join(*cacheDir, hashOf([]byte(abs(job.Compressed))))
+}
+
+// The name of a job isn't part of the key, for a target it holds the line of
+// its entry. Diagnostics point at the sources, so their names are.
+func cacheKey(job *Job, sources [][]byte, outputs []string) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "gocss %s\nyui %v\ncomments %v\nmerge %v\noverride %v\nlower %v\nbidi %q\npretty %v %q\nprecision %d\n",
+		VERSION, job.Yui, job.Comments, job.Merge, job.Override, job.Lower, job.BidiPrefix, *pretty, *indent, *precision)
+	for _, name := range outputs {
+		fmt.Fprintf(h, "output %s\n", name)
+	}
+	// sources are prefixed with their length so they can't run into each other
+	for i, data := range sources {
+		fmt.Fprintf(h, "source %s %d\n", job.Sources[i], len(data))
+		h.Write(data)
+	}
+	return fmt.Sprintf("%x", h.Sum())
+}
+
+// The key on the first line, a hash and a name for every output, an empty
+// line and the diagnostics. Missing or broken entries are nil.
+func ReadCacheEntry(name string) (e *CacheEntry) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil { return nil }
+	i := bytes.Index(data, []byte("\n\n"))
+	if i < 0 { return nil }
+	e = &CacheEntry{Outputs: make(map[string] string), Diagnostics: data[i+2:]}
+	lines := strings.Split(string(data[:i]), "\n")
+	e.Key = lines[0]
+	for _, line := range lines[1:] {
+		n := strings.Index(line, " ")
+		if n < 0 { return nil }
+		e.Outputs[line[n+1:]] = line[:n]
+	}
+	return
+}
+
+// True when the entry was built with key and none of the outputs has changed
+// since
+func (e *CacheEntry) Matches(key string, outputs []string) bool {
+	if e == nil || e.Key != key || len(e.Outputs) != len(outputs) { return false }
+	for _, name := range outputs {
+		data, err := ioutil.ReadFile(name)
+		if err != nil || hashOf(data) != e.Outputs[name] { return false }
+	}
+	return true
+}
+
+// Records the outputs as they are on disk
+func WriteCacheEntry(name, key string, outputs []string, diagnostics []byte) (err os.Error) {
+	f, err := CreateAtomicFile(name)
+	if err != nil { return }
+	out := new(bytes.Buffer)
+	fmt.Fprintf(out, "%s\n", key)
+	for _, o := range outputs {
+		data, err := ioutil.ReadFile(o)
+		if err != nil {
+			f.Abort()
+			return err
+		}
+		fmt.Fprintf(out, "%s %s\n", hashOf(data), o)
+	}
+	out.WriteString("\n")
+	out.Write(diagnostics)
+	if _, err = f.Write(out.Bytes()); err != nil {
+		f.Abort()
+		return
+	}
+	_, err = f.Commit()
+	return
+}
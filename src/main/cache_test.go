@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// every option that changes the outputs changes the key
+func TestCacheKey(t *testing.T) {
+	job := func() *Job { return &Job{Name: "Makefile.gcs:3: target a", Sources: []string{"a.css"}, Comments: true} }
+	sources := [][]byte{[]byte("a{}")}
+	outputs := []string{"a-c.css"}
+	base := cacheKey(job(), sources, outputs)
+	if cacheKey(job(), [][]byte{[]byte("a{}")}, []string{"a-c.css"}) != base { t.Errorf("key isn't stable") }
+
+	for _, test := range []struct {
+		what    string
+		change  func(job *Job)
+		sources [][]byte
+		outputs []string
+	}{
+		{"yui", func(j *Job) { j.Yui = true }, sources, outputs},
+		{"comments", func(j *Job) { j.Comments = false }, sources, outputs},
+		{"merge", func(j *Job) { j.Merge = true }, sources, outputs},
+		{"override", func(j *Job) { j.Override = true }, sources, outputs},
+		{"lower", func(j *Job) { j.Lower = true }, sources, outputs},
+		{"bidi", func(j *Job) { j.BidiPrefix = "[dir=%s] " }, sources, outputs},
+		{"source", nil, [][]byte{[]byte("b{}")}, outputs},
+		// diagnostics name the sources
+		{"source name", func(j *Job) { j.Sources = []string{"b.css"} }, sources, outputs},
+		// sources can't run into each other
+		{"split sources", func(j *Job) { j.Sources = []string{"a.css", ""} }, [][]byte{[]byte("a"), []byte("{}")}, outputs},
+		{"rtl output", nil, sources, []string{"a-c.css", "a-rtl-c.css"}},
+	} {
+		j := job()
+		if test.change != nil { test.change(j) }
+		if cacheKey(j, test.sources, test.outputs) == base { t.Errorf("%s: same key", test.what) }
+	}
+
+	// the entry of a target moved in the configuration
+	j := job()
+	j.Name = "Makefile.gcs:4: target a"
+	if cacheKey(j, sources, outputs) != base { t.Errorf("name: other key") }
+
+	defer func(p int) { *precision = p }(*precision)
+	*precision = 2
+	if cacheKey(job(), sources, outputs) == base { t.Errorf("precision: same key") }
+}
+
+func TestCacheEntry(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "a-c.css")
+	entry := filepath.Join(dir, "cache", "entry")
+	writeFile(t, out, "a{}")
+	if err := WriteCacheEntry(entry, "key", []string{out}, []byte("a.css:1: warning\n")); err != nil { t.Fatal(err) }
+
+	e := ReadCacheEntry(entry)
+	if e == nil || e.Key != "key" || string(e.Diagnostics) != "a.css:1: warning\n" { t.Fatalf("read %+v", e) }
+	for _, test := range []struct {
+		what    string
+		key     string
+		outputs []string
+		data    string
+		want    bool
+	}{
+		{"same", "key", []string{out}, "a{}", true},
+		{"other key", "other", []string{out}, "a{}", false},
+		{"other outputs", "key", []string{out, out + ".rtl"}, "a{}", false},
+		{"output changed", "key", []string{out}, "b{}", false},
+		{"output removed", "key", []string{out}, "", false},
+	} {
+		os.Remove(out)
+		if test.data != "" { writeFile(t, out, test.data) }
+		if e.Matches(test.key, test.outputs) != test.want { t.Errorf("%s: matches %v", test.what, !test.want) }
+	}
+
+	// missing and broken entries match nothing
+	writeFile(t, entry, "key\n")
+	if e := ReadCacheEntry(entry); e != nil || e.Matches("key", nil) { t.Errorf("broken entry read as %+v", e) }
+	if e := ReadCacheEntry(filepath.Join(dir, "missing")); e != nil { t.Errorf("missing entry read as %+v", e) }
+}
+
+// jobs are skipped until their sources or outputs change, diagnostics are
+// repeated
+func TestProcessFileCached(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	defer func(d string, c, v bool) { *cacheDir, *noCache, *verbose = d, c, v }(*cacheDir, *noCache, *verbose)
+	*cacheDir, *noCache, *verbose = filepath.Join(dir, "cache"), false, false
+
+	in := filepath.Join(dir, "a.css")
+	job := &Job{Name: in, Sources: []string{in}, Compressed: filepath.Join(dir, "a-c.css"), Comments: true}
+	writeFile(t, in, "a { color: red; }}")
+	for _, step := range []struct {
+		what   string
+		change func()
+		cached bool
+		out    string
+	}{
+		{"first", nil, false, "a{color:red}}"},
+		{"again", nil, true, "a{color:red}}"},
+		{"source changed", func() { writeFile(t, in, "a { color: green; }}") }, false, "a{color:green}}"},
+		{"output changed", func() { writeFile(t, job.Compressed, "") }, false, "a{color:green}}"},
+		{"output removed", func() { os.Remove(job.Compressed) }, false, "a{color:green}}"},
+		{"again", nil, true, "a{color:green}}"},
+		{"entry moved", func() { job.Name = "Makefile.gcs:9: target a" }, true, "a{color:green}}"},
+	} {
+		if step.change != nil { step.change() }
+		log := new(bytes.Buffer)
+		cached, err := processFile(job, 0, log)
+		if err != nil { t.Fatalf("%s: %s", step.what, err) }
+		if cached != step.cached { t.Errorf("%s: cached %v", step.what, cached) }
+		if log.Len() == 0 { t.Errorf("%s: diagnostics lost", step.what) }
+		if s := readFile(t, job.Compressed); s != step.out { t.Errorf("%s: output %q", step.what, s) }
+	}
+}
@@ -0,0 +1,347 @@
+// Makefile.gcs configuration files
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const SAMPLE_CONFIG = `# gocss configuration
+#
+# Lines are "key = value" pairs, everything after a # is ignored. Settings
+# before the first [target] apply to every input and are the defaults for
+# the targets.
+
+# suffixes used to name outputs, same as -g, -c, -G and -C
+suffix-generated      = -gen.css
+suffix-compressed     = -c.css
+suffix-rtl-generated  = -rtl.css
+suffix-rtl-compressed = -rtl-c.css
+
+# where outputs go, same as -out-dir and -out-name. {dir} is the directory
+# of the input, {name} its name without suffix-generated or extension and
+# {suffix} the suffix of the output. Outputs other than the compressed one
+# are named {dir}/{name}{suffix} unless the template has a {suffix}.
+out-dir  =
+out-name = {dir}/{name}{suffix}
+
+# files compressed on their own, may be given more than once. Directories
+# are searched for files ending in suffix-generated, ** matches any number of
+# directories and @name reads names from a file. Files and directories
+# matching the patterns in a .gocssignore are skipped.
+input = css/**/*-gen.css
+
+# default options
+yui           = false   # match YUI Compressor output
+rtl           = false   # also write a compressed right to left version
+rtl-generated = false   # also write a right to left version of the input
+bidi          = false   # compress into one stylesheet for both directions
+logical       = false   # replace logical properties with physical ones
+comments      = true    # keep /*! ... */ comments
+merge         = false   # merge duplicate and adjacent rules
+override      = false   # drop declarations overridden in the same block
+
+# selectors of direction dependent rules in bidi output, same as -D
+bidi-prefix = [dir=%s]
+
+# a target concatenates its sources, in order, and writes, by default
+#   css/site-c.css           compressed
+#   css/site-gen.css         concatenation, with -o
+#   css/site-rtl-c.css       with rtl = true
+#   css/site-rtl.css         with rtl-generated = true
+[css/site]
+sources = css/reset.css css/layout.css css/widgets/*.css
+rtl     = true
+`
+
+// Options of a target or of files compressed on their own
+type Target struct {
+	// base name of the outputs
+	Name         string
+	Sources      []string
+	Yui          bool
+	RTL          bool
+	RTLGenerated bool
+	Bidi         bool
+	Lower        bool
+	Comments     bool
+	Merge        bool
+	Override     bool
+	// entry of the target in the configuration
+	file         string
+	line         int
+}
+
+// Outputs are put next to their input, with their suffix
+const DEFAULT_TEMPLATE = "{dir}/{name}{suffix}"
+
+type Config struct {
+	Generated     string
+	Compressed    string
+	RTLGenerated  string
+	RTLCompressed string
+	BidiPrefix    string
+	// outputs are mirrored below OutDir when it is set
+	OutDir        string
+	Template      string
+	// files compressed on their own, with the default options
+	Inputs        []string
+	Defaults      Target
+	Targets       []*Target
+	// inputs and sources found in directories or by patterns are true, those
+	// also given by name false
+	found         map[string] bool
+	// directories and lists inputs and sources were found in
+	watched       map[string] bool
+}
+
+type ConfigError struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *ConfigError) String() string {
+	return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+}
+
+func parseBool(s string) (b bool, ok bool) {
+	switch strings.ToLower(s) {
+	case "true", "yes", "on", "1":
+		return true, true
+	case "false", "no", "off", "0":
+		return false, true
+	}
+	return false, false
+}
+
+// Reads a configuration, settings not in the file keep the values in cfg
+func ReadConfig(cfg *Config, name string, r io.Reader) (err os.Error) {
+	reader := bufio.NewReader(r)
+	var target *Target
+	for n := 1; ; n++ {
+		line, rerr := reader.ReadString('\n')
+		if rerr != nil && rerr != os.EOF { return rerr }
+		if i := strings.Index(line, "#"); i >= 0 { line = line[:i] }
+		line = strings.TrimSpace(line)
+
+		fail := func(format string, args ...interface{}) os.Error {
+			return &ConfigError{name, n, fmt.Sprintf(format, args...)}
+		}
+
+		switch {
+		case line == "":
+		case line[0] == '[':
+			if line[len(line)-1] != ']' || len(strings.TrimSpace(line[1:len(line)-1])) == 0 {
+				return fail("expected [target name]")
+			}
+			if target != nil && len(target.Sources) == 0 {
+				return &ConfigError{name, target.line, "target " + target.Name + " has no sources"}
+			}
+			t := cfg.Defaults
+			t.Name = strings.TrimSpace(line[1:len(line)-1])
+			t.file, t.line = name, n
+			target = &t
+			cfg.Targets = append(cfg.Targets, target)
+		default:
+			i := strings.Index(line, "=")
+			if i < 0 { return fail("expected key = value") }
+			key := strings.TrimSpace(line[:i])
+			value := strings.TrimSpace(line[i+1:])
+			options := &cfg.Defaults
+			if target != nil { options = target }
+
+			switch key {
+			case "yui", "rtl", "rtl-generated", "bidi", "logical", "comments", "merge", "override":
+				b, ok := parseBool(value)
+				if !ok { return fail("%s must be true or false, not %q", key, value) }
+				switch key {
+				case "yui":
+					options.Yui = b
+				case "rtl":
+					options.RTL = b
+				case "rtl-generated":
+					options.RTLGenerated = b
+				case "bidi":
+					options.Bidi = b
+				case "logical":
+					options.Lower = b
+				case "comments":
+					options.Comments = b
+				case "merge":
+					options.Merge = b
+				case "override":
+					options.Override = b
+				}
+			case "sources":
+				if target == nil { return fail("sources outside of a [target]") }
+				files, err := cfg.expand(strings.Fields(value), ".css")
+				if err != nil { return fail("%s", err) }
+				target.Sources = append(target.Sources, files...)
+			case "out-dir":
+				if target != nil { return fail("%s is only allowed before the first [target]", key) }
+				cfg.OutDir = value
+			case "input", "suffix-generated", "suffix-compressed", "suffix-rtl-generated", "suffix-rtl-compressed", "bidi-prefix", "out-name":
+				if target != nil { return fail("%s is only allowed before the first [target]", key) }
+				if value == "" { return fail("%s needs a value", key) }
+				switch key {
+				case "input":
+					files, err := cfg.expand(strings.Fields(value), cfg.Generated)
+					if err != nil { return fail("%s", err) }
+					cfg.Inputs = append(cfg.Inputs, files...)
+				case "suffix-generated":
+					cfg.Generated = value
+				case "suffix-compressed":
+					cfg.Compressed = value
+				case "suffix-rtl-generated":
+					cfg.RTLGenerated = value
+				case "suffix-rtl-compressed":
+					cfg.RTLCompressed = value
+				case "bidi-prefix":
+					cfg.BidiPrefix = value + " "
+				case "out-name":
+					if strings.Index(value, "{name}") < 0 { return fail("out-name needs a {name}") }
+					cfg.Template = value
+				}
+			default:
+				return fail("unknown setting %q", key)
+			}
+		}
+
+		if rerr == os.EOF { break }
+	}
+	if target != nil && len(target.Sources) == 0 {
+		return &ConfigError{name, target.line, "target " + target.Name + " has no sources"}
+	}
+	return
+}
+
+func (cfg *Config) expand(args []string, suffix string) ([]string, os.Error) {
+	if cfg.found == nil { cfg.found = make(map[string] bool) }
+	if cfg.watched == nil { cfg.watched = make(map[string] bool) }
+	return expand(args, suffix, cfg.found, cfg.watched)
+}
+
+func ReadConfigFile(cfg *Config, name string) (err os.Error) {
+	f, err := os.Open(name)
+	if err != nil { return }
+	defer f.Close()
+	return ReadConfig(cfg, name, f)
+}
+
+// directory and base name of an input, the generated suffix is only removed
+// from the end and otherwise the extension is
+func (cfg *Config) split(path string) (dir, name string) {
+	dir, name = filepath.Split(path)
+	if len(name) > len(cfg.Generated) && strings.HasSuffix(name, cfg.Generated) {
+		name = name[:len(name)-len(cfg.Generated)]
+	} else {
+		name = name[:len(name)-len(filepath.Ext(name))]
+	}
+	return
+}
+
+// Path of an output with the given suffix, compressed is the main output
+func (cfg *Config) output(dir, name, suffix string, compressed bool) string {
+	template := cfg.Template
+	if template == "" || (!compressed && strings.Index(template, "{suffix}") < 0) { template = DEFAULT_TEMPLATE }
+	if dir == "" { dir = "." }
+	// inputs outside the current directory are mirrored by their absolute path
+	if cfg.OutDir != "" && strings.HasPrefix(filepath.Clean(dir), "..") { dir = abs(dir) }
+	path := strings.Replace(template, "{dir}", dir, -1)
+	path = strings.Replace(path, "{name}", name, -1)
+	path = strings.Replace(path, "{suffix}", suffix, -1)
+	return filepath.Join(cfg.OutDir, path)
+}
+
+func abs(path string) string {
+	if a, err := filepath.Abs(path); err == nil { return a }
+	return filepath.Clean(path)
+}
+
+// The file path names after following links, outputs are written there.
+// Links in the directory of a missing file are followed as well.
+func resolve(path string) string {
+	a := abs(path)
+	if r, err := filepath.EvalSymlinks(a); err == nil { return r }
+	dir, base := filepath.Split(a)
+	if r, err := filepath.EvalSymlinks(dir); err == nil { return filepath.Join(r, base) }
+	return a
+}
+
+// One job per input and target. Outputs found in directories or by patterns
+// are left out of the sources, so the next run finds the same ones. Fails
+// when an output would replace an input or the output of another job.
+func (cfg *Config) Jobs() (jobs []*Job, err os.Error) {
+	var all []*Job
+	for _, name := range cfg.Inputs {
+		t := cfg.Defaults
+		t.Sources = []string{name}
+		dir, base := cfg.split(name)
+		all = append(all, cfg.job(&t, func(suffix string, compressed bool) string {
+			return cfg.output(dir, base, suffix, compressed)
+		}))
+	}
+	for _, t := range cfg.Targets {
+		dir, base := filepath.Split(t.Name)
+		all = append(all, cfg.job(t, func(suffix string, compressed bool) string {
+			return cfg.output(dir, base, suffix, compressed)
+		}))
+	}
+
+	outputs := make(map[string] bool)
+	for _, job := range all {
+		for _, out := range []string{job.Compressed, job.Generated, job.RTL, job.RTLGenerated} {
+			if out != "" { outputs[resolve(out)] = true }
+		}
+	}
+	for i, job := range all {
+		var sources []string
+		for _, s := range job.Sources {
+			if !cfg.found[s] || !outputs[resolve(s)] { sources = append(sources, s) }
+		}
+		switch {
+		case len(sources) > 0:
+			job.Sources = sources
+			jobs = append(jobs, job)
+		case i >= len(cfg.Inputs):
+			return nil, os.NewError(job.Name + ": no sources other than outputs")
+		}
+	}
+
+	inputs := make(map[string] bool)
+	for _, job := range jobs {
+		for _, s := range job.Sources {
+			inputs[resolve(s)] = true
+		}
+	}
+	written := make(map[string] bool)
+	for _, job := range jobs {
+		for _, out := range []string{job.Compressed, job.Generated, job.RTL, job.RTLGenerated} {
+			if out == "" { continue }
+			a := resolve(out)
+			if inputs[a] { return nil, os.NewError(job.Name + ": refusing to overwrite input " + out) }
+			if written[a] { return nil, os.NewError(job.Name + ": " + out + " is written more than once") }
+			written[a] = true
+		}
+	}
+	return
+}
+
+func (cfg *Config) job(t *Target, output func(suffix string, compressed bool) string) (job *Job) {
+	job = &Job{Sources: t.Sources, Lower: t.Lower, Yui: t.Yui, Comments: t.Comments, Merge: t.Merge, Override: t.Override}
+	if t.Bidi { job.BidiPrefix = cfg.BidiPrefix }
+	job.Name = t.Sources[0]
+	// errors of a target point at its entry, positions in its sources
+	// refer to the source they are in
+	if t.file != "" { job.Name = fmt.Sprintf("%s:%d: target %s", t.file, t.line, t.Name) }
+	job.Compressed = output(cfg.Compressed, true)
+	if len(t.Sources) > 1 && *generate { job.Generated = output(cfg.Generated, false) }
+	if t.RTL { job.RTL = output(cfg.RTLCompressed, false) }
+	if t.RTLGenerated { job.RTLGenerated = output(cfg.RTLGenerated, false) }
+	return
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readConfig(text string) (*Config, os.Error) {
+	cfg := flagConfig()
+	err := ReadConfig(cfg, "Makefile.gcs", strings.NewReader(text))
+	return cfg, err
+}
+
+// errors name the configuration and the line of the entry at fault
+var configErrorTests = []struct{ in, err string }{
+	{"x = 1", `Makefile.gcs:1: unknown setting "x"`},
+	{"\n\nfoo", "Makefile.gcs:3: expected key = value"},
+	{"# a\n[]", "Makefile.gcs:2: expected [target name]"},
+	{"yui = maybe", `Makefile.gcs:1: yui must be true or false, not "maybe"`},
+	{"sources = a.css", "Makefile.gcs:1: sources outside of a [target]"},
+	{"[a]\nsources = a.css\nout-dir = x", "Makefile.gcs:3: out-dir is only allowed before the first [target]"},
+	{"out-name = x", "Makefile.gcs:1: out-name needs a {name}"},
+	{"input =", "Makefile.gcs:1: input needs a value"},
+	{"[a]", "Makefile.gcs:1: target a has no sources"},
+	{"[a]\nsources = a.css\n[b]\n\n[c]\nsources = c.css", "Makefile.gcs:3: target b has no sources"},
+}
+
+func TestReadConfigErrors(t *testing.T) {
+	for _, test := range configErrorTests {
+		_, err := readConfig(test.in)
+		if err == nil || err.String() != test.err {
+			t.Errorf("%q: error %v, want %s", test.in, err, test.err)
+		}
+	}
+}
+
+func TestTargetJobs(t *testing.T) {
+	cfg, err := readConfig("yui = true\n\n[css/site]\nsources = a.css b.css\nrtl = true\n[one]\nsources = c.css\nyui = false\n")
+	if err != nil { t.Fatal(err) }
+	jobs, err := cfg.Jobs()
+	if err != nil { t.Fatal(err) }
+	if len(jobs) != 2 { t.Fatalf("%d jobs", len(jobs)) }
+
+	site, one := jobs[0], jobs[1]
+	if site.Name != "Makefile.gcs:3: target css/site" || len(site.Sources) != 2 || !site.Yui ||
+			site.Compressed != "css/site-c.css" || site.RTL != "css/site-rtl-c.css" || site.Generated != "" {
+		t.Errorf("css/site: %+v", site)
+	}
+	if one.Name != "Makefile.gcs:6: target one" || one.Sources[0] != "c.css" || one.Yui || one.RTL != "" {
+		t.Errorf("one: %+v", one)
+	}
+}
+
+func TestJobsErrors(t *testing.T) {
+	for _, test := range []struct{ in, err string }{
+		{"[a]\nsources = a-c.css", "Makefile.gcs:1: target a: refusing to overwrite input a-c.css"},
+		{"[a]\nsources = x.css\n[./a]\nsources = y.css", "Makefile.gcs:3: target ./a: a-c.css is written more than once"},
+	} {
+		cfg, err := readConfig(test.in)
+		if err == nil { _, err = cfg.Jobs() }
+		if err == nil || err.String() != test.err {
+			t.Errorf("%q: error %v, want %s", test.in, err, test.err)
+		}
+	}
+}
+
+// outputs of inputs compressed on their own, the right to left one is
+// named with its suffix unless the template has one
+var outputTests = []struct {
+	outDir, template, in, compressed, rtl string
+}{
+	{"", DEFAULT_TEMPLATE, "css/a-gen.css", "css/a-c.css", "css/a-rtl-c.css"},
+	{"", DEFAULT_TEMPLATE, "css/a.css", "css/a-c.css", "css/a-rtl-c.css"},
+	{"", DEFAULT_TEMPLATE, "a.css", "a-c.css", "a-rtl-c.css"},
+	// the generated suffix is only removed from the end
+	{"", DEFAULT_TEMPLATE, "a-gen.css.bak", "a-gen.css-c.css", "a-gen.css-rtl-c.css"},
+	{"", DEFAULT_TEMPLATE, "-gen.css", "-gen-c.css", "-gen-rtl-c.css"},
+	{"build", DEFAULT_TEMPLATE, "css/a.css", "build/css/a-c.css", "build/css/a-rtl-c.css"},
+	{"build", DEFAULT_TEMPLATE, "./a.css", "build/a-c.css", "build/a-rtl-c.css"},
+	{"", "{dir}/{name}.min.css", "css/a.css", "css/a.min.css", "css/a-rtl-c.css"},
+	{"", "{dir}/min/{name}{suffix}", "css/a.css", "css/min/a-c.css", "css/min/a-rtl-c.css"},
+	{"build", "{name}.css", "css/a.css", "build/a.css", "build/css/a-rtl-c.css"},
+}
+
+func TestOutputs(t *testing.T) {
+	for _, test := range outputTests {
+		cfg := flagConfig()
+		cfg.OutDir, cfg.Template = test.outDir, test.template
+		cfg.Defaults.RTL = true
+		cfg.Inputs = []string{test.in}
+		jobs, err := cfg.Jobs()
+		if err != nil {
+			t.Errorf("%s: %s", test.in, err)
+			continue
+		}
+		if jobs[0].Compressed != test.compressed || jobs[0].RTL != test.rtl {
+			t.Errorf("%s in %q as %q: %s and %s, want %s and %s", test.in, test.outDir, test.template,
+				jobs[0].Compressed, jobs[0].RTL, test.compressed, test.rtl)
+		}
+	}
+}
+
+// inputs outside the current directory are mirrored by their absolute path
+func TestOutputsOutside(t *testing.T) {
+	cfg := flagConfig()
+	cfg.OutDir = "build"
+	cfg.Inputs = []string{"../x/a.css"}
+	jobs, err := cfg.Jobs()
+	if err != nil { t.Fatal(err) }
+	if want := filepath.Join("build", abs("../x"), "a-c.css"); jobs[0].Compressed != want {
+		t.Errorf("%s, want %s", jobs[0].Compressed, want)
+	}
+}
+
+func TestOutputsRefused(t *testing.T) {
+	for _, test := range []struct{ template string; in []string; err string }{
+		{"{dir}/{name}.css", []string{"a.css"}, "a.css: refusing to overwrite input a.css"},
+		{"{dir}/{name}.css", []string{"css/a.less", "css/a.css"}, "css/a.less: refusing to overwrite input css/a.css"},
+		{DEFAULT_TEMPLATE, []string{"a.css", "a-c.css"}, "a.css: refusing to overwrite input a-c.css"},
+		{"{name}{suffix}", []string{"a/x.css", "b/x.css"}, "b/x.css: x-c.css is written more than once"},
+	} {
+		cfg := flagConfig()
+		cfg.Template = test.template
+		cfg.Inputs = test.in
+		_, err := cfg.Jobs()
+		if err == nil || err.String() != test.err { t.Errorf("%v as %q: error %v, want %s", test.in, test.template, err, test.err) }
+	}
+}
@@ -6,67 +6,11 @@ package main
 
 import (
 	"os"
-	"bufio"
-	"fmt"
 	"./lexer"
 )
 
-type InputFileStreamer struct {
-	In  *os.File
-	Out chan(int)
-}
-
-func (fs *InputFileStreamer) Run() {
-	reader := bufio.NewReader(fs.In)
-	for {
-		r, s, er := reader.ReadRune()
-		switch {
-		case s < 0:
-			fmt.Fprintf(os.Stderr, "error reading: %s\n", er.String())
-			os.Exit(1)
-		case s == 0: // EOF
-			fs.Out <- -1
-			return
-		case s > 0:
-			fs.Out <- r
-		}
-	}
-}
-
-func CreateInputFileStreamer(file *os.File) (ifs *InputFileStreamer, out chan(int)) {
-	out = make(chan(int))
-	ifs = &InputFileStreamer{In: file, Out: out}
-	return
-}
-
 var ZERO_STR string
 
-type OutputFileStreamer struct {
-	In  chan(string)
-	Out *os.File
-	Eof chan(int)
-}
-
-func (fs *OutputFileStreamer) Run() {
-	var s string
-	for {
-		s = <- fs.In
-		switch (s) {
-		case ZERO_STR:
-			if fs.Eof != nil { fs.Eof <- 0 }
-			return
-		default:
-			fs.Out.WriteString(s)
-		}
-	}
-}
-
-func CreateOutputFileStreamer (in chan(string), file *os.File) (ofs *OutputFileStreamer, eof chan(int)) {
-	eof = make(chan(int))
-	ofs = &OutputFileStreamer{In: in, Out: file, Eof: eof}
-	return
-}
-
 type TeeFileStreamer struct {
 	In   chan(string)
 	Out  chan(string)
@@ -91,6 +35,8 @@ type TokenValueFileStreamer struct {
 	In   chan(lexer.TokenValue)
 	Out  chan(lexer.TokenValue)
 	File *os.File
+	// first error writing to File
+	Err  os.Error
 }
 
 func (fs *TokenValueFileStreamer) Run() {
@@ -102,13 +48,13 @@ func (fs *TokenValueFileStreamer) Run() {
 		case s.Token == lexer.EndToken:
 			return
 		default:
-			fs.File.WriteString(s.Value)
+			if _, err := fs.File.WriteString(s.Value); err != nil && fs.Err == nil { fs.Err = err }
 		}
 	}
 }
 
 func CreateTokenValueFileStreamer(in chan(lexer.TokenValue), file *os.File) (tvfs *TokenValueFileStreamer, out chan(lexer.TokenValue)) {
-	out = make(chan(lexer.TokenValue))
+	out = make(chan(lexer.TokenValue), lexer.BUFFER)
 	tvfs = &TokenValueFileStreamer{In: in, File: file, Out: out}
 	return
 }
@@ -130,8 +76,8 @@ func (fs *ChannelSplitter) Run() {
 }
 
 func CreateChannelSplitter(in chan(lexer.TokenValue)) (cs *ChannelSplitter, out1 chan(lexer.TokenValue), out2 chan(lexer.TokenValue)) {
-	out1 = make(chan(lexer.TokenValue))
-	out2 = make(chan(lexer.TokenValue))
+	out1 = make(chan(lexer.TokenValue), lexer.BUFFER)
+	out2 = make(chan(lexer.TokenValue), lexer.BUFFER)
 	cs = &ChannelSplitter{In: in, Out1: out1, Out2: out2}
 	return
 }
@@ -3,180 +3,452 @@ package main
 
 import (
 	"./lexer"
-	"./parser"
 	"./rtl"
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
+	"os/signal"
 	"flag"
 	"runtime"
+	"strconv"
 	"strings"
 )
 
+// version of the compressor, outputs cached by other versions are rebuilt
+const VERSION = "0.13"
+
 // general options
 var stdin *bool = flag.Bool("i", false, "Read from <STDIN> and write compressed data to <STDOUT>")
 var suffixGenerated *string = flag.String("g", "-gen.css", "Suffix of generated files")
 var suffixCompressed *string = flag.String("c", "-c.css", "Suffix of compressed files")
 var verbose *bool = flag.Bool("v", false, "Print progress information")
 var yui *bool = flag.Bool("y", false, "Match output to YUI Compressor v2.4.6")
+var merge *bool = flag.Bool("m", false, "Merge duplicate and adjacent rules")
+var override *bool = flag.Bool("override", false, "Drop declarations overridden in the same block")
+var concurrent *bool = flag.Bool("p", false, "Tokenize and compress in separate goroutines")
+var precision *int = flag.Int("precision", -1, "Digits numbers are rounded to after the point, -1 keeps them all")
+var workers *int = flag.Int("j", runtime.NumCPU(), "Number of files processed at the same time")
+// std in
+var stdinName *string = flag.String("stdin-filename", "", "Name of the stylesheet read with -i, used in diagnostics")
+var rtlOut *string = flag.String("rtl-out", "", "With -i, also write the compressed right to left version to this file, fd:N for a file descriptor")
+var rtlGenOut *string = flag.String("rtl-gen-out", "", "With -i, also write the right to left version of the input to this file, fd:N for a file descriptor")
+// beautify
+var pretty *bool = flag.Bool("b", false, "Beautify instead of compressing")
+var indent *string = flag.String("I", "  ", "Indentation of beautified output")
+var suffixBeautified *string = flag.String("B", "-pretty.css", "Suffix of beautified files")
 // right to left conversion
-var convert *bool = flag.Bool("r", false, "Convert for right to left languages")
-var convertGen *bool = flag.Bool("R", false, "Convert generated file for right to left languages")
+var convert *bool = flag.Bool("r", false, "Convert for right to left languages, with -i instead of the normal output unless -rtl-out is given")
+var convertGen *bool = flag.Bool("R", false, "Convert generated file for right to left languages, with -i without compressing unless -rtl-gen-out is given")
 var suffixRTLS *string = flag.String("G", "-rtl.css", "Suffix of generated RTL files")
 var suffixRTL *string = flag.String("C", "-rtl-c.css", "Suffix of compressed RTL files")
+var bidi *bool = flag.Bool("d", false, "Compress into one stylesheet for both directions")
+var bidiPrefix *string = flag.String("D", "[dir=%s] ", "Selector prefix of direction dependent rules with -d, %s is ltr or rtl")
+// logical properties
+var lower *bool = flag.Bool("l", false, "Replace logical properties and values with physical ones, right to left output gets its own")
+var raise *bool = flag.Bool("L", false, "With -i, rewrite physical properties to logical ones without compressing")
 // configuration file
 var config *string = flag.String("f", "Makefile.gcs", "File to read configuration from")
 var createConfig *bool = flag.Bool("T", false, "Output a sample configuration file")
 var generate *bool = flag.Bool("o", false, "Output generated files")
+// output paths
+var outDir *string = flag.String("out-dir", "", "Directory the outputs are written to, mirroring the inputs")
+var outName *string = flag.String("out-name", DEFAULT_TEMPLATE, "Path of compressed outputs, {dir} and {name} are those of the input, {suffix} that of the output")
+// build cache
+var cacheDir *string = flag.String("cache-dir", ".gocss-cache", "Directory of the build cache")
+var noCache *bool = flag.Bool("no-cache", false, "Build every file, without using the cache")
+var clearCache *bool = flag.Bool("clear-cache", false, "Remove the build cache and exit")
+// keep running
+var watching *bool = flag.Bool("watch", false, "Rebuild the outputs of inputs that change, until interrupted")
+
+// closed on the first interrupt
+var cancel = make(chan(int))
+
+// Files read and written for one input or target
+type Job struct {
+	// concatenated in order
+	Sources      []string
+	// the input, or the entry of the target in the configuration, in errors
+	Name         string
+	Compressed   string
+	// outputs left empty aren't written
+	Generated    string
+	RTL          string
+	RTLGenerated string
+	// selector prefix of bidirectional output, empty for normal output
+	BidiPrefix   string
+	// logical properties are replaced with physical ones
+	Lower        bool
+	// the compressed output is flipped for right to left languages
+	Flip         bool
+	Yui          bool
+	Comments     bool
+	// rules with the same selectors or declarations are merged
+	Merge        bool
+	// declarations overridden in the same block are dropped
+	Override     bool
+}
 
 func main() {
 	flag.Parse()
+	// set once, the jobs of a rebuild in watch mode may be fewer
+	if *workers > 0 { runtime.GOMAXPROCS(*workers) }
+
+	if *createConfig {
+		os.Stdout.WriteString(SAMPLE_CONFIG)
+		return
+	}
+
+	if *clearCache {
+		if err := os.RemoveAll(*cacheDir); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := checkFlags(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(2)
+	}
 
 	// std in, of option selected
 	if *stdin {
+		go interrupts(nil)
 		stream()
 		return
 	}
 
-	// list of files given on command line
-	if flag.NArg() > 0 {
-		convertArgs()
-		return
-	}
+	go interrupts(cancel)
 
-	// read from configuration file
-	cfg, err := os.Open(*config)
+	jobs, watched, err := loadJobs()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Couldn't open config file (%s): %s\n", *config, err)
+		fmt.Fprintf(os.Stderr, "%s\n", err)
 		os.Exit(2)
 	}
-	defer cfg.Close()
+	if *watching {
+		watch(jobs, watched)
+		return
+	}
+	if convertArgs(jobs) > 0 { os.Exit(1) }
 }
 
-// convert from stdin
-func stream() {
-	// set up channels
-	runes := make(chan(int))
-	tokenValues := make(chan(lexer.TokenValue))
-	minified := make(chan(string))
-	eof := make(chan(int))
-
-	ifs := &InputFileStreamer{In: os.Stdin, Out: runes}
-	lexer := &lexer.Lexer{In: runes, Out: tokenValues}
-	parser := &parser.Parser{In: tokenValues, Out: minified, Yui: *yui}
-	ofs := &OutputFileStreamer{In: minified, Out: os.Stdout, Eof: eof}
-
-	go ifs.Run()
-	go lexer.Run()
-	go parser.Run()
-	go ofs.Run()
-
-	// wait for chain to finish
-	<- eof
+// Fails for flags that don't work together
+func checkFlags() os.Error {
+	switch {
+	case *raise && !*stdin:
+		// files and targets are always compressed
+		return os.NewError("-L only works with -i")
+	case !*stdin && strings.Index(*outName, "{name}") < 0:
+		return os.NewError("-out-name needs a {name}")
+	}
+	return nil
 }
 
-// convert list of files given on command line
-func convertArgs() {
-	threads := 4
-	n := flag.NArg()
-	if threads > n { threads = n }
-	runtime.GOMAXPROCS(threads)
+// jobs of the files given on command line or the configuration file, and the
+// directories and lists they were found in
+func loadJobs() (jobs []*Job, watched map[string] bool, err os.Error) {
+	cfg := flagConfig()
+	if flag.NArg() > 0 {
+		if cfg.Inputs, err = cfg.expand(flag.Args(), cfg.Generated); err != nil { return }
+	} else if err = ReadConfigFile(cfg, *config); err != nil {
+		if _, ok := err.(*ConfigError); !ok {
+			err = fmt.Errorf("Couldn't open config file (%s): %s", *config, err)
+		}
+		return
+	}
+	jobs, err = cfg.Jobs()
+	return jobs, cfg.watched, err
+}
 
-	queue := make(chan string, 64)
-	result := make(chan int, threads)
-	for i := 0; i < threads; i++ {
-		go processFiles(i, queue, result)
+// settings from the command line, the configuration file may override them
+func flagConfig() (cfg *Config) {
+	cfg = &Config{
+		Generated:     *suffixGenerated,
+		Compressed:    *suffixCompressed,
+		RTLGenerated:  *suffixRTLS,
+		RTLCompressed: *suffixRTL,
+		BidiPrefix:    *bidiPrefix,
+		OutDir:        *outDir,
+		Template:      *outName,
+		Defaults:      Target{Yui: *yui, RTL: *convert, RTLGenerated: *convertGen, Bidi: *bidi, Lower: *lower, Comments: true, Merge: *merge, Override: *override},
 	}
+	if *pretty { cfg.Compressed = *suffixBeautified }
+	return
+}
 
-	for i := 0; i < n; i++ {
-		queue <- flag.Arg(i)
+// convert from stdin to stdout, extra outputs go to the files named with
+// -rtl-out and -rtl-gen-out
+func stream() {
+	if *raise {
+		raiseStream()
+		return
 	}
 
-	// wait for all jobs to complete
-	for i := 0 ; i < threads; i++ {
-		<-result
+	job := &Job{Name: *stdinName, Lower: *lower, Yui: *yui, Comments: true, Merge: *merge, Override: *override}
+	out := &Outputs{Compressed: os.Stdout}
+	var atomic []*AtomicFile
+	open := func(name string) *os.File {
+		f, a, err := openOutput(name)
+		if err != nil {
+			for _, a := range atomic {
+				a.Abort()
+			}
+			exit(nil, err)
+		}
+		if a != nil { atomic = append(atomic, a) }
+		return f
 	}
-}
 
-func processFiles(i int, queue chan(string), result chan(int)) {
-	for {
-		select {
-		case name := <-queue:
-			processFile(name, i)
-		default:
-			result <- 0
-			return
+	// without a file of their own, right to left outputs replace the normal one
+	switch {
+	case *rtlGenOut != "":
+		job.RTLGenerated = *rtlGenOut
+		out.RTLGenerated = open(*rtlGenOut)
+	case *convertGen:
+		out.Compressed, out.RTLGenerated = nil, os.Stdout
+	}
+	switch {
+	case *rtlOut != "":
+		job.RTL = *rtlOut
+		out.RTL = open(*rtlOut)
+	case *convert:
+		job.Flip = true
+	}
+	if *bidi && !job.Flip { job.BidiPrefix = *bidiPrefix }
+
+	read, write := runPipeline(job, lexer.CreateSource(job.Name), os.Stdin, out, os.Stderr, nil, 0)
+	for _, f := range atomic {
+		if read != nil || write != nil {
+			f.Abort()
+		} else if _, err := f.Commit(); err != nil {
+			write = err
 		}
 	}
+	exit(read, write)
 }
 
-func processFile(name string, threadNum int) {
-	fi, err := os.Open(name)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "can't open %s: error %s\n", name, err)
+// rewrite physical properties to logical ones, without compressing
+func raiseStream() {
+	src := lexer.CreateSource(*stdinName)
+	lex := lexer.CreateReader(os.Stdin)
+	lex.Source = src
+	go lex.Run()
+
+	logical, out := rtl.CreateLogical(lex.Out, rtl.ToLogical)
+	go logical.Run()
+	var err os.Error
+	for tv := <- out; tv.Token != lexer.EndToken; tv = <- out {
+		if err == nil { _, err = os.Stdout.WriteString(tv.Value) }
+	}
+	exit(lex.Err, err)
+}
+
+// Opens an extra output of stdin mode. fd:N is a file descriptor opened by
+// the caller, other names are files replaced once they are complete.
+func openOutput(name string) (f *os.File, atomic *AtomicFile, err os.Error) {
+	if strings.HasPrefix(name, "fd:") {
+		fd, e := strconv.Atoi(name[3:])
+		if e != nil || fd < 0 { return nil, nil, os.NewError("bad file descriptor " + name) }
+		return os.NewFile(fd, name), nil, nil
+	}
+	// devices and pipes can't be replaced
+	if fi, e := os.Stat(name); e == nil && !fi.IsRegular() {
+		f, err = os.Create(name)
 		return
 	}
-	defer fi.Close()
+	if atomic, err = CreateAtomicFile(name); err != nil { return }
+	return atomic.File, atomic, nil
+}
 
-	target := strings.Replace(name, *suffixGenerated, *suffixCompressed, 1)
-	fo, err := os.Create(target)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Can't create %s: error %s\n", target, err)
+// exits with an error message when reading or writing failed
+func exit(read, write os.Error) {
+	switch {
+	case read != nil:
+		fmt.Fprintf(os.Stderr, "error reading: %s\n", read)
+	case write != nil:
+		fmt.Fprintf(os.Stderr, "error writing: %s\n", write)
+	default:
 		return
 	}
-	defer fo.Close()
+	os.Exit(1)
+}
 
-	ifs, runes := CreateInputFileStreamer(fi)
-	go ifs.Run()
+// Closes cancel on the first interrupt and exits on the second, or on the
+// first when there is nothing to cancel
+func interrupts(cancel chan(int)) {
+	for sig := range signal.Incoming {
+		if sig != os.SIGINT { continue }
+		if cancel == nil {
+			removeTemps()
+			os.Exit(130)
+		}
+		fmt.Fprintf(os.Stderr, "interrupted, finishing the files in progress\n")
+		close(cancel)
+		cancel = nil
+	}
+}
 
-	lexer, tokenValues := lexer.CreateLexer(runes)
-	go lexer.Run()
+// convert list of files given on command line or in the configuration,
+// returns the number of jobs that failed or were cancelled
+func convertArgs(jobs []*Job) (failed int) {
+	threads := *workers
+	n := len(jobs)
+	if threads > n { threads = n }
+	if threads < 1 { threads = 1 }
 
-	if *convertGen {
-		rtlGenName := strings.Replace(name, *suffixGenerated, *suffixRTLS, 1)
-		rtlGenFile, err := os.Create(rtlGenName)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Can't create %s: error %s\n", rtlGenName, err)
-			return
+	// indexes of the jobs, every one is received by exactly one worker
+	queue := make(chan(int))
+	results := make(chan(*Result))
+	for i := 0; i < threads; i++ {
+		go processFiles(i, jobs, queue, results)
+	}
+
+	go func() {
+		defer close(queue)
+		for i := range jobs {
+			select {
+			case <- cancel:
+				return
+			default:
+			}
+			select {
+			case queue <- i:
+			case <- cancel:
+				return
+			}
 		}
-		defer rtlGenFile.Close()
+	}()
 
-		if *verbose { fmt.Fprintf(os.Stderr, "[%d] Converting: %s\n", threadNum, rtlGenName) }
+	// the log of each job is written once those of the jobs before it are,
+	// workers send nil when they are done
+	done := make([]*Result, n)
+	next := 0
+	flush := func() {
+		for ; next < n && done[next] != nil; next++ {
+			os.Stderr.Write(done[next].Log)
+		}
+	}
+	finished, hits := 0, 0
+	for running := threads; running > 0; {
+		r := <- results
+		if r == nil {
+			running--
+			continue
+		}
+		done[r.Index] = r
+		finished++
+		if r.Err != nil { failed++ }
+		if r.Cached { hits++ }
+		flush()
+	}
+	// logs after the jobs that were cancelled
+	for ; next < n; next++ {
+		if done[next] != nil { os.Stderr.Write(done[next].Log) }
+	}
 
-		// split channels
-		gensplitter, out1, out2 := CreateChannelSplitter(tokenValues)
-		go gensplitter.Run()
-		genconverter := rtl.CreateConverter(out2, rtlGenFile)
-		go genconverter.Run()
-		tokenValues = out1
+	skipped := n - finished
+	if *verbose || failed > 0 || skipped > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d jobs done, %d failed", finished - failed, n, failed)
+		if skipped > 0 { fmt.Fprintf(os.Stderr, ", %d cancelled", skipped) }
+		if *verbose && !*noCache { fmt.Fprintf(os.Stderr, ", %d cache hits, %d misses", hits, finished - hits) }
+		fmt.Fprintf(os.Stderr, "\n")
 	}
+	return failed + skipped
+}
 
-	if *verbose { fmt.Fprintf(os.Stderr, "[%d] Compressing: %s\n", threadNum, target) }
+// Outcome of a job, Err is the first thing that went wrong
+type Result struct {
+	Index int
+	Err   os.Error
+	// the outputs were up to date
+	Cached bool
+	// progress and diagnostics
+	Log   []byte
+}
 
-	parser, minified := parser.CreateParser(tokenValues, *yui)
-	go parser.Run()
+func processFiles(i int, jobs []*Job, queue chan(int), results chan(*Result)) {
+	for n := range queue {
+		log := new(bytes.Buffer)
+		job := jobs[n]
+		cached, err := processFile(job, i, log)
+		if err != nil { fmt.Fprintf(log, "%s: %s\n", job.Name, err) }
+		results <- &Result{n, err, cached, log.Bytes()}
+	}
+	results <- nil
+}
 
-	if *convert {
-		rtlName := strings.Replace(name, *suffixGenerated, *suffixRTL, 1)
-//		rtlFile, err := os.Create(rtlName)
-//		if err != nil {
-//			fmt.Fprintf(os.Stderr, "Can't create %s: error %s\n", rtl, err)
-//			return
-//		}
-//		defer rtlFile.Close()
+// Builds the outputs of a job, or leaves them alone when the cache has them
+func processFile(job *Job, threadNum int, log io.Writer) (cached bool, err os.Error) {
+	// sources are separated by a newline so tokens can't run into each other
+	var readers []io.Reader
+	var sources [][]byte
+	src := lexer.CreateSource(job.Name)
+	line := 1
+	for i, name := range job.Sources {
+		data, err := ioutil.ReadFile(name)
+		if err != nil { return false, err }
+		if i > 0 { readers = append(readers, strings.NewReader("\n")) }
+		readers = append(readers, bytes.NewBuffer(data))
+		sources = append(sources, data)
+		// diagnostics refer to the source, not the concatenation
+		src.Parts = append(src.Parts, lexer.Part{name, line})
+		line += bytes.Count(data, []byte("\n")) + 1
+	}
 
-		if *verbose { fmt.Fprintf(os.Stderr, "[%d] Converting: %s\n", threadNum, rtlName) }
+	outputs := []string{job.Compressed, job.Generated, job.RTLGenerated, job.RTL}
+	if *pretty { outputs = outputs[:1] }
+	var written []string
+	for _, name := range outputs {
+		if name != "" { written = append(written, name) }
+	}
 
-		// split channels
-//		splitter, out3, out4 := CreateChannelSplitter(minified)
-//		go splitter.Run()
-//		converter := rtl.CreateConverter(out4, rtlFile)
-//		go converter.Run()
-//		minified = out3
+	var key, entry string
+	if !*noCache {
+		key = cacheKey(job, sources, written)
+		entry = entryName(job)
+		if e := ReadCacheEntry(entry); e.Matches(key, written) {
+			if *verbose { fmt.Fprintf(log, "[%d] Cached: %s\n", threadNum, job.Compressed) }
+			log.Write(e.Diagnostics)
+			return true, nil
+		}
 	}
 
-	ofs, eof := CreateOutputFileStreamer(minified, fo)
-	go ofs.Run()
+	// all outputs are created before the pipeline starts, so a failure
+	// doesn't leave stages behind waiting. They replace their files once
+	// every one of them is complete.
+	files := make([]*os.File, 4)
+	var atomic []*AtomicFile
+	diagnostics := new(bytes.Buffer)
+	defer func() {
+		for _, f := range atomic {
+			if err != nil {
+				f.Abort()
+				continue
+			}
+			changed, e := f.Commit()
+			if e != nil && err == nil { err = e }
+			if !changed && e == nil && *verbose { fmt.Fprintf(log, "[%d] Unchanged: %s\n", threadNum, f.Name) }
+		}
+		// a cache that can't be written only costs time
+		if err == nil && key != "" {
+			if e := WriteCacheEntry(entry, key, written, diagnostics.Bytes()); e != nil {
+				fmt.Fprintf(log, "[%d] Not cached: %s\n", threadNum, e)
+			}
+		}
+	}()
+	for i, name := range outputs {
+		if name == "" { continue }
+		f, e := CreateAtomicFile(name)
+		if e != nil { return false, e }
+		atomic = append(atomic, f)
+		files[i] = f.File
+	}
+	out := &Outputs{files[0], files[1], files[2], files[3]}
 
-	// wait for chain to finish
-	<- eof
+	// a read error explains the others
+	read, write := runPipeline(job, src, io.MultiReader(readers...), out, io.MultiWriter(log, diagnostics), log, threadNum)
+	if read != nil { return false, read }
+	return false, write
 }
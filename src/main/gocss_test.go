@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "gocss-test")
+	if err != nil { t.Fatal(err) }
+	return dir
+}
+
+func writeFile(t *testing.T, name, data string) {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil { t.Fatal(err) }
+	if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil { t.Fatal(err) }
+}
+
+func readFile(t *testing.T, name string) string {
+	data, err := ioutil.ReadFile(name)
+	if err != nil { t.Fatal(err) }
+	return string(data)
+}
+
+// names in dir other than those given, temporary files left behind
+func leftovers(t *testing.T, dir string, names ...string) (extra []string) {
+	d, err := os.Open(dir)
+	if err != nil { t.Fatal(err) }
+	found, err := d.Readdirnames(-1)
+	d.Close()
+	if err != nil { t.Fatal(err) }
+	for _, f := range found {
+		ok := false
+		for _, name := range names {
+			ok = ok || f == name
+		}
+		if !ok { extra = append(extra, f) }
+	}
+	return
+}
+
+// options set for the length of a test, without the build cache
+func quietly(f func()) {
+	defer func(c, v bool) { *noCache, *verbose = c, v }(*noCache, *verbose)
+	*noCache, *verbose = true, false
+	f()
+}
+
+// a failing job reports why and leaves the outputs as they were
+func TestProcessFileErrors(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "a.css")
+	out := filepath.Join(dir, "a-c.css")
+	writeFile(t, in, "a { color: red }")
+	writeFile(t, filepath.Join(dir, "file"), "")
+
+	for _, job := range []*Job{
+		{Sources: []string{filepath.Join(dir, "missing.css")}, Compressed: out},
+		{Sources: []string{in, filepath.Join(dir, "missing.css")}, Compressed: out},
+		// the directory of the second output is a file
+		{Sources: []string{in}, Compressed: out, RTL: filepath.Join(dir, "file", "a-rtl-c.css")},
+	} {
+		writeFile(t, out, "old")
+		quietly(func() {
+			if _, err := processFile(job, 0, ioutil.Discard); err == nil { t.Errorf("%v: no error", job.Sources) }
+		})
+		if s := readFile(t, out); s != "old" { t.Errorf("%v: output replaced with %q", job.Sources, s) }
+		if extra := leftovers(t, dir, "a.css", "a-c.css", "file"); extra != nil { t.Errorf("%v: left %v", job.Sources, extra) }
+	}
+}
+
+// every job is built, those that fail are counted
+func TestConvertArgsFailures(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	var jobs []*Job
+	for _, name := range []string{"a", "missing", "b", "missing2"} {
+		in := filepath.Join(dir, name + ".css")
+		if name[0] != 'm' { writeFile(t, in, "a { color: red }") }
+		jobs = append(jobs, &Job{Name: in, Sources: []string{in}, Compressed: filepath.Join(dir, name + "-c.css"), Comments: true})
+	}
+	var failed int
+	quietly(func() { failed = convertArgs(jobs) })
+	if failed != 2 { t.Errorf("%d failed, want 2", failed) }
+	for _, name := range []string{"a", "b"} {
+		if s := readFile(t, filepath.Join(dir, name + "-c.css")); s != "a{color:red}" { t.Errorf("%s: %q", name, s) }
+	}
+	if extra := leftovers(t, dir, "a.css", "b.css", "a-c.css", "b-c.css"); extra != nil { t.Errorf("left %v", extra) }
+}
+
+// every job is built once, whatever the number of workers
+func TestWorkerPool(t *testing.T) {
+	defer func(j int) { *workers = j }(*workers)
+	for _, test := range []struct{ jobs, workers int }{{0, 4}, {1, 4}, {8, 16}, {50, 3}, {20, 1}, {5, 0}} {
+		dir := tempDir(t)
+		var jobs []*Job
+		var names []string
+		for i := 0; i < test.jobs; i++ {
+			in := filepath.Join(dir, fmt.Sprintf("%d.css", i))
+			writeFile(t, in, fmt.Sprintf(".a%d { margin: 0px }", i))
+			jobs = append(jobs, &Job{Name: in, Sources: []string{in}, Compressed: in + ".min", Comments: true})
+			names = append(names, filepath.Base(in), filepath.Base(in) + ".min")
+		}
+		*workers = test.workers
+		var failed int
+		quietly(func() { failed = convertArgs(jobs) })
+		if failed != 0 { t.Errorf("%d jobs, -j %d: %d failed", test.jobs, test.workers, failed) }
+		for i, job := range jobs {
+			if s := readFile(t, job.Compressed); s != fmt.Sprintf(".a%d{margin:0}", i) { t.Errorf("%d jobs, -j %d: %s is %q", test.jobs, test.workers, job.Compressed, s) }
+		}
+		if extra := leftovers(t, dir, names...); extra != nil { t.Errorf("%d jobs, -j %d: left %v", test.jobs, test.workers, extra) }
+		os.RemoveAll(dir)
+	}
+}
+
+// once interrupted, jobs that haven't started are cancelled
+func TestConvertArgsCancelled(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	defer func(c chan(int)) { cancel = c }(cancel)
+	cancel = make(chan(int))
+	close(cancel)
+
+	in := filepath.Join(dir, "a.css")
+	writeFile(t, in, "a { color: red }")
+	jobs := []*Job{{Name: in, Sources: []string{in}, Compressed: filepath.Join(dir, "a-c.css")}}
+	var failed int
+	quietly(func() { failed = convertArgs(jobs) })
+	if failed != 1 { t.Errorf("%d failed or cancelled, want 1", failed) }
+	if extra := leftovers(t, dir, "a.css"); extra != nil { t.Errorf("left %v", extra) }
+}
+
+func TestCheckFlags(t *testing.T) {
+	defer func(r, i bool, n string) { *raise, *stdin, *outName = r, i, n }(*raise, *stdin, *outName)
+	for _, test := range []struct {
+		raise, stdin bool
+		outName      string
+		err          string
+	}{
+		{false, false, "{name}", ""},
+		{true, true, "{name}", ""},
+		{true, false, "{name}", "-L only works with -i"},
+		{false, false, "site", "-out-name needs a {name}"},
+		{false, true, "site", ""},
+	} {
+		*raise, *stdin, *outName = test.raise, test.stdin, test.outName
+		msg := ""
+		if err := checkFlags(); err != nil { msg = err.String() }
+		if msg != test.err { t.Errorf("%+v: error %q", test, msg) }
+	}
+}
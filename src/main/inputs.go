@@ -0,0 +1,300 @@
+// Finding inputs in directories, patterns and lists of files
+package main
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// patterns of files and directories left out when walking directories
+const IGNORE_FILE = ".gocssignore"
+
+// file list read from stdin with -, kept for reloads in watch mode
+var stdinList []string
+var stdinRead bool
+
+// Patterns of an ignore file, those with a / are matched against the path
+// relative to dir and the others against the last element of a path. A
+// trailing / only matches directories.
+type ignore struct {
+	dir      string
+	patterns []string
+}
+
+func readIgnore(dir string) (ig *ignore, err os.Error) {
+	f, err := os.Open(filepath.Join(dir, IGNORE_FILE))
+	if err != nil {
+		// no ignore file
+		return nil, nil
+	}
+	defer f.Close()
+	patterns, err := readList(f)
+	if err != nil { return }
+	return &ignore{dir, patterns}, nil
+}
+
+func (ig *ignore) matches(path string, dir bool) bool {
+	rel := path
+	if ig.dir != "." { rel = strings.TrimLeft(path[len(ig.dir):], "/") }
+	for _, p := range ig.patterns {
+		if p[len(p)-1] == '/' {
+			if !dir { continue }
+			p = p[:len(p)-1]
+		}
+		var ok bool
+		if strings.Index(p, "/") >= 0 {
+			ok, _ = filepath.Match(strings.TrimLeft(p, "/"), rel)
+		} else {
+			ok, _ = filepath.Match(p, filepath.Base(path))
+		}
+		if ok { return true }
+	}
+	return false
+}
+
+// Lines of r, without blank lines and # comments
+func readList(r io.Reader) (lines []string, err os.Error) {
+	reader := bufio.NewReader(r)
+	for {
+		line, rerr := reader.ReadString('\n')
+		if rerr != nil && rerr != os.EOF { return nil, rerr }
+		if line = strings.TrimSpace(line); line != "" && line[0] != '#' { lines = append(lines, line) }
+		if rerr == os.EOF { return }
+	}
+	panic("unreachable")
+}
+
+// Calls visit for dir and the files and directories below it, in order,
+// skipping hidden and ignored ones and symbolic links to directories
+func walk(dir string, ignores []*ignore, visit func(path string, dir bool)) os.Error {
+	visit(dir, true)
+	ig, err := readIgnore(dir)
+	if err != nil { return err }
+	if ig != nil { ignores = append(append([]*ignore(nil), ignores...), ig) }
+
+	d, err := os.Open(dir)
+	if err != nil { return err }
+	names, err := d.Readdirnames(-1)
+	d.Close()
+	if err != nil { return err }
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name[0] == '.' { continue }
+		path := filepath.Join(dir, name)
+		fi, err := os.Lstat(path)
+		if err != nil { return err }
+		ignored := false
+		for _, ig := range ignores {
+			ignored = ignored || ig.matches(path, fi.IsDirectory())
+		}
+		switch {
+		case ignored:
+		case fi.IsDirectory():
+			if err = walk(path, ignores, visit); err != nil { return err }
+		case fi.IsRegular():
+			visit(path, false)
+		}
+	}
+	return nil
+}
+
+// the ignore file of the current directory applies to every walk
+func baseIgnores(root string) (ignores []*ignore, err os.Error) {
+	if root == "." { return }
+	ig, err := readIgnore(".")
+	if ig != nil { ignores = append(ignores, ig) }
+	return
+}
+
+func matchParts(pattern, parts []string) bool {
+	switch {
+	case len(pattern) == 0:
+		return len(parts) == 0
+	case pattern[0] == "**":
+		for i := 0; i <= len(parts); i++ {
+			if matchParts(pattern[1:], parts[i:]) { return true }
+		}
+		return false
+	case len(parts) == 0:
+		return false
+	}
+	ok, _ := filepath.Match(pattern[0], parts[0])
+	return ok && matchParts(pattern[1:], parts[1:])
+}
+
+// filepath.Glob with ** matching any number of directories. Dirs are those
+// a new match would appear in: every directory below the last one without
+// wildcards with **, otherwise those matching a part of the pattern.
+func glob(pattern string) (files, dirs []string, err os.Error) {
+	// the last directory without wildcards
+	parts := strings.Split(pattern, "/")
+	n := 0
+	for n < len(parts) && strings.IndexAny(parts[n], "*?[") < 0 {
+		n++
+	}
+	root := strings.Join(parts[:n], "/")
+	switch {
+	case root == "" && pattern[0] == '/':
+		root = "/"
+	case root == "":
+		root = "."
+	}
+
+	if strings.Index(pattern, "**") < 0 {
+		dirs = append(dirs, root)
+		for i := n + 1; i < len(parts); i++ {
+			matches, _ := filepath.Glob(strings.Join(parts[:i], "/"))
+			for _, m := range matches {
+				if fi, e := os.Stat(m); e == nil && fi.IsDirectory() { dirs = append(dirs, m) }
+			}
+		}
+		files, err = filepath.Glob(pattern)
+		return
+	}
+
+	if _, e := os.Stat(root); e != nil {
+		// nothing matches, like filepath.Glob
+		return nil, []string{root}, nil
+	}
+	ignores, err := baseIgnores(root)
+	if err != nil { return }
+	err = walk(root, ignores, func(path string, dir bool) {
+		if dir {
+			dirs = append(dirs, path)
+			return
+		}
+		rel := path
+		if root != "." { rel = strings.TrimLeft(path[len(root):], "/") }
+		if matchParts(parts[n:], strings.Split(rel, "/")) { files = append(files, path) }
+	})
+	return
+}
+
+// State of an expansion, ignore files are read once
+type expansion struct {
+	suffix  string
+	seen    map[string] bool
+	// names found in directories or by patterns, false once they are given
+	found   map[string] bool
+	ignores map[string] *ignore
+	files   []string
+	// directories walked or searched and lists read, new files show up
+	// there
+	watched map[string] bool
+}
+
+func (e *expansion) ignoreFile(dir string) (ig *ignore, err os.Error) {
+	ig, ok := e.ignores[dir]
+	if ok { return }
+	if ig, err = readIgnore(dir); err == nil { e.ignores[dir] = ig }
+	return
+}
+
+// Whether path is left out by the ignore file of the current directory or
+// of one of the directories on the path, like when walking them
+func (e *expansion) ignored(path string, dir bool) (bool, os.Error) {
+	ig, err := e.ignoreFile(".")
+	if err != nil { return false, err }
+	var ignores []*ignore
+	if ig != nil { ignores = append(ignores, ig) }
+	parts := strings.Split(path, "/")
+	for i := range parts[:len(parts)-1] {
+		d := strings.Join(parts[:i+1], "/")
+		// the root and the parents of the current directory aren't walked
+		if d == "" || parts[i] == ".." { continue }
+		for _, ig := range ignores {
+			if ig.matches(d, true) { return true, nil }
+		}
+		ig, err := e.ignoreFile(d)
+		if err != nil { return false, err }
+		if ig != nil { ignores = append(ignores, ig) }
+	}
+	for _, ig := range ignores {
+		if ig.matches(path, dir) { return true, nil }
+	}
+	return false, nil
+}
+
+func (e *expansion) expand(args []string) (err os.Error) {
+	for _, arg := range args {
+		var found []string
+		given := false
+		switch {
+		case arg == "-":
+			if !stdinRead {
+				if stdinList, err = readList(os.Stdin); err != nil { return }
+				stdinRead = true
+			}
+			err = e.expand(stdinList)
+		case len(arg) > 1 && arg[0] == '@':
+			e.watched[arg[1:]] = true
+			f, ferr := os.Open(arg[1:])
+			if ferr != nil { return ferr }
+			list, ferr := readList(f)
+			f.Close()
+			if ferr != nil { return ferr }
+			err = e.expand(list)
+		case strings.IndexAny(arg, "*?[") >= 0:
+			var dirs []string
+			found, dirs, err = glob(arg)
+			for _, d := range dirs {
+				e.watched[filepath.Clean(d)] = true
+			}
+			if err == nil && len(found) == 0 { err = os.NewError("no files match " + arg) }
+		default:
+			fi, serr := os.Stat(arg)
+			if serr != nil || !fi.IsDirectory() {
+				// missing files are reported when they are opened
+				found = []string{arg}
+				given = true
+				break
+			}
+			var skip bool
+			if skip, err = e.ignored(filepath.Clean(arg), true); err != nil { return }
+			if skip { continue }
+			var ignores []*ignore
+			if ignores, err = baseIgnores(arg); err != nil { return }
+			suffix := e.suffix
+			if suffix == "" { suffix = ".css" }
+			err = walk(arg, ignores, func(path string, dir bool) {
+				switch {
+				case dir:
+					e.watched[filepath.Clean(path)] = true
+				case strings.HasSuffix(path, suffix):
+					found = append(found, path)
+				}
+			})
+		}
+		if err != nil { return }
+		for _, name := range found {
+			name = filepath.Clean(name)
+			skip, ierr := e.ignored(name, false)
+			if ierr != nil { return ierr }
+			if skip { continue }
+			if _, ok := e.found[name]; !ok || given { e.found[name] = !given }
+			if !e.seen[name] { e.files = append(e.files, name) }
+			e.seen[name] = true
+		}
+	}
+	return
+}
+
+// Files named by arguments. Directories are walked for files ending in
+// suffix, patterns may use ** for any number of directories, @name reads
+// arguments from a file, one per line, and - reads them from stdin. Files
+// are only listed once, and left out when an ignore file matches them.
+// Names found in directories or by patterns are true in found, unless they
+// are given as well. The directories and lists new files would show up in
+// are added to watched.
+func expand(args []string, suffix string, found, watched map[string] bool) (files []string, err os.Error) {
+	if found == nil { found = make(map[string] bool) }
+	if watched == nil { watched = make(map[string] bool) }
+	e := &expansion{suffix, make(map[string] bool), found, make(map[string] *ignore), nil, watched}
+	err = e.expand(args)
+	return e.files, err
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Runs f in a directory holding files, named by their path and given by
+// their content
+func inDir(t *testing.T, files map[string] string, f func()) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	for name, data := range files {
+		writeFile(t, filepath.Join(dir, name), data)
+	}
+	wd, err := os.Getwd()
+	if err != nil { t.Fatal(err) }
+	if err = os.Chdir(dir); err != nil { t.Fatal(err) }
+	defer os.Chdir(wd)
+	f()
+}
+
+var tree = map[string] string{
+	".gocssignore":         "vendor/\n# comment\n",
+	"css/.gocssignore":     "old-*\n",
+	"css/a-gen.css":        "",
+	"css/old-gen.css":      "",
+	"vendor/lib-gen.css":   "",
+	"lib/vendor/x-gen.css": "",
+	"lib/y-gen.css":        "",
+	"list.txt":             "css/a-gen.css\n\n# comment\n  lib/y-gen.css\n",
+}
+
+// ignore files apply to every file, whether it is found in a directory, by a
+// pattern or named
+var expandTests = []struct {
+	args         []string
+	files, found string
+}{
+	{[]string{"."}, "css/a-gen.css lib/y-gen.css", "css/a-gen.css lib/y-gen.css"},
+	{[]string{"**/*-gen.css"}, "css/a-gen.css lib/y-gen.css", "css/a-gen.css lib/y-gen.css"},
+	{[]string{"**/y-gen.css"}, "lib/y-gen.css", "lib/y-gen.css"},
+	{[]string{"css/*.css"}, "css/a-gen.css", "css/a-gen.css"},
+	{[]string{"css"}, "css/a-gen.css", "css/a-gen.css"},
+	{[]string{"vendor/lib-gen.css", "css/old-gen.css", "lib/vendor/x-gen.css"}, "", ""},
+	{[]string{"vendor", "lib/vendor", "vendor/*.css"}, "", ""},
+	{[]string{"vendor", "css/a-gen.css", "lib"}, "css/a-gen.css lib/y-gen.css", "lib/y-gen.css"},
+	{[]string{"@list.txt"}, "css/a-gen.css lib/y-gen.css", ""},
+	{[]string{"./css/a-gen.css", "css", "lib"}, "css/a-gen.css lib/y-gen.css", "lib/y-gen.css"},
+	// missing files are reported when they are opened
+	{[]string{"missing.css"}, "missing.css", ""},
+}
+
+func TestExpand(t *testing.T) {
+	inDir(t, tree, func() {
+		for _, test := range expandTests {
+			found := make(map[string] bool)
+			files, err := expand(test.args, "-gen.css", found, nil)
+			if err != nil {
+				t.Errorf("%v: %s", test.args, err)
+				continue
+			}
+			if s := strings.Join(files, " "); s != test.files { t.Errorf("%v: %q, want %q", test.args, s, test.files) }
+			if s := names(found); s != test.found { t.Errorf("%v: found %q, want %q", test.args, s, test.found) }
+		}
+	})
+}
+
+// new files would show up in the directories walked or searched, or in lists
+func TestExpandWatched(t *testing.T) {
+	inDir(t, tree, func() {
+		for _, test := range []struct{ args []string; want string }{
+			{[]string{"."}, ". css lib"},
+			{[]string{"lib"}, "lib"},
+			{[]string{"**/*-gen.css"}, ". css lib"},
+			{[]string{"css/*.css"}, "css"},
+			{[]string{"*/*-gen.css"}, ". css lib vendor"},
+			{[]string{"@list.txt"}, "list.txt"},
+			{[]string{"css/a-gen.css"}, ""},
+		} {
+			watched := make(map[string] bool)
+			if _, err := expand(test.args, "-gen.css", nil, watched); err != nil { t.Errorf("%v: %s", test.args, err) }
+			if s := names(watched); s != test.want { t.Errorf("%v: watched %q, want %q", test.args, s, test.want) }
+		}
+	})
+}
+
+func TestExpandErrors(t *testing.T) {
+	inDir(t, tree, func() {
+		for _, test := range []struct{ arg, err string }{
+			{"css/*.less", "no files match css/*.less"},
+			{"@missing.txt", "open missing.txt: no such file or directory"},
+		} {
+			_, err := expand([]string{test.arg}, "-gen.css", nil, nil)
+			if err == nil || err.String() != test.err { t.Errorf("%s: error %v, want %s", test.arg, err, test.err) }
+		}
+	})
+}
+
+// files given after an ignored directory are still compressed
+func TestIgnoredDirectory(t *testing.T) {
+	files := map[string] string{".gocssignore": "vendor/\n", "vendor/a-gen.css": "a{}", "b-gen.css": "b { margin: 0px }"}
+	inDir(t, files, func() {
+		cfg := flagConfig()
+		var err os.Error
+		if cfg.Inputs, err = cfg.expand([]string{"vendor", "b-gen.css"}, cfg.Generated); err != nil { t.Fatal(err) }
+		jobs, err := cfg.Jobs()
+		if err != nil { t.Fatal(err) }
+		var failed int
+		quietly(func() { failed = convertArgs(jobs) })
+		if failed != 0 || len(jobs) != 1 { t.Fatalf("%d jobs, %d failed", len(jobs), failed) }
+		if s := readFile(t, "b-c.css"); s != "b{margin:0}" { t.Errorf("b-c.css is %q", s) }
+		if _, err := os.Stat("vendor/a-c.css"); err == nil { t.Errorf("vendor/a-gen.css compressed") }
+	})
+}
+
+// outputs of the last run aren't sources of the next one
+func TestOutputsNotSources(t *testing.T) {
+	files := map[string] string{"css/a.css": "", "css/a-c.css": "", "css/site-c.css": "", "css/site-rtl-c.css": ""}
+	inDir(t, files, func() {
+		cfg, err := readConfig("input = css/*.css\n[css/site]\nsources = css/\nrtl = true\n")
+		if err != nil { t.Fatal(err) }
+		jobs, err := cfg.Jobs()
+		if err != nil { t.Fatal(err) }
+		if len(jobs) != 2 || jobs[0].Compressed != "css/a-c.css" || strings.Join(jobs[1].Sources, " ") != "css/a.css" {
+			for _, job := range jobs {
+				t.Errorf("%+v", job)
+			}
+		}
+
+		for _, test := range []struct{ in, err string }{
+			{"[css/site]\nsources = css/site-c.css", "Makefile.gcs:1: target css/site: refusing to overwrite input css/site-c.css"},
+			{"[css/site]\nsources = css/site-*.css\nrtl = true", "Makefile.gcs:1: target css/site: no sources other than outputs"},
+		} {
+			cfg, err := readConfig(test.in)
+			if err == nil { _, err = cfg.Jobs() }
+			if err == nil || err.String() != test.err { t.Errorf("%q: error %v, want %s", test.in, err, test.err) }
+		}
+	})
+}
@@ -0,0 +1,96 @@
+// Outputs that only replace their file once they are complete
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// temporary files not yet moved into place, removed when gocss is killed
+var temps = make(map[string] bool)
+var tempsLock sync.Mutex
+// number of the next temporary file
+var tempCount int
+
+func removeTemps() {
+	tempsLock.Lock()
+	defer tempsLock.Unlock()
+	for name := range temps {
+		os.Remove(name)
+	}
+}
+
+// File written to a temporary file in the directory of Name, Commit renames
+// it to Name and Abort removes it
+type AtomicFile struct {
+	*os.File
+	Name string
+}
+
+func CreateAtomicFile(name string) (f *AtomicFile, err os.Error) {
+	// a link stays, the file it points to is replaced
+	if target, e := filepath.EvalSymlinks(name); e == nil { name = target }
+	dir, base := filepath.Split(name)
+	if dir == "" { dir = "." }
+	if err = os.MkdirAll(dir, 0755); err != nil { return }
+	// like a file created in place, the umask applies
+	var file *os.File
+	for {
+		tempsLock.Lock()
+		temp := filepath.Join(dir, fmt.Sprintf(".%s.%d.%d", base, os.Getpid(), tempCount))
+		tempCount++
+		file, err = os.OpenFile(temp, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if err == nil { temps[temp] = true }
+		tempsLock.Unlock()
+		// left behind by a process with the same id
+		if pe, ok := err.(*os.PathError); ok && pe.Error == os.EEXIST { continue }
+		break
+	}
+	if err != nil { return }
+	f = &AtomicFile{file, name}
+	return
+}
+
+func (f *AtomicFile) forget() {
+	tempsLock.Lock()
+	temps[f.File.Name()] = false, false
+	tempsLock.Unlock()
+}
+
+func (f *AtomicFile) Abort() {
+	f.File.Close()
+	os.Remove(f.File.Name())
+	f.forget()
+}
+
+// Moves the output into place, an existing file with the same content is
+// left alone so its modification time stays the same
+func (f *AtomicFile) Commit() (changed bool, err os.Error) {
+	temp := f.File.Name()
+	if err = f.File.Close(); err != nil {
+		f.Abort()
+		return
+	}
+	defer f.forget()
+
+	// a replaced file keeps its permissions
+	if fi, e := os.Stat(f.Name); e == nil {
+		old, e1 := ioutil.ReadFile(f.Name)
+		out, e2 := ioutil.ReadFile(temp)
+		if e1 == nil && e2 == nil && bytes.Equal(old, out) {
+			os.Remove(temp)
+			return false, nil
+		}
+		err = os.Chmod(temp, fi.Permission())
+	}
+	if err == nil { err = os.Rename(temp, f.Name) }
+	if err != nil {
+		os.Remove(temp)
+		return
+	}
+	return true, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func writeAtomic(t *testing.T, name, data string) *AtomicFile {
+	f, err := CreateAtomicFile(name)
+	if err != nil { t.Fatal(err) }
+	if _, err = f.WriteString(data); err != nil { t.Fatal(err) }
+	return f
+}
+
+func pending() int {
+	tempsLock.Lock()
+	defer tempsLock.Unlock()
+	return len(temps)
+}
+
+var atomicTests = []struct {
+	// content before, empty for no file
+	old, data string
+	commit    bool
+	// content after and whether the file was replaced
+	out       string
+	changed   bool
+}{
+	{"", "a{}", true, "a{}", true},
+	{"b{}", "a{}", true, "a{}", true},
+	{"a{}", "a{}", true, "a{}", false},
+	{"b{}", "a{}", false, "b{}", false},
+	{"", "a{}", false, "", false},
+}
+
+func TestAtomicFile(t *testing.T) {
+	for _, test := range atomicTests {
+		dir := tempDir(t)
+		name := filepath.Join(dir, "a-c.css")
+		if test.old != "" { writeFile(t, name, test.old) }
+		before := stampOf(name)
+
+		f := writeAtomic(t, name, test.data)
+		// nothing is visible before the commit
+		if s := stampOf(name); s != before { t.Errorf("%+v: written before the commit", test) }
+		changed := false
+		if test.commit {
+			var err os.Error
+			if changed, err = f.Commit(); err != nil { t.Errorf("%+v: %s", test, err) }
+		} else {
+			f.Abort()
+		}
+
+		out := ""
+		if test.out != "" { out = readFile(t, name) }
+		if out != test.out || changed != test.changed { t.Errorf("%+v: %q, changed %v", test, out, changed) }
+		if !test.changed && stampOf(name) != before { t.Errorf("%+v: file touched", test) }
+		names := []string{}
+		if test.out != "" { names = append(names, "a-c.css") }
+		if extra := leftovers(t, dir, names...); extra != nil { t.Errorf("%+v: left %v", test, extra) }
+		if n := pending(); n != 0 { t.Errorf("%+v: %d temporary files remembered", test, n) }
+		os.RemoveAll(dir)
+	}
+}
+
+// a replaced file keeps its permissions, new ones get those of the umask
+func TestAtomicFilePermissions(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	defer syscall.Umask(syscall.Umask(027))
+	for _, test := range []struct{ name string; old uint32; want uint32 }{{"old", 0604, 0604}, {"new", 0, 0640}} {
+		name := filepath.Join(dir, test.name)
+		if test.old != 0 {
+			writeFile(t, name, "")
+			if err := os.Chmod(name, test.old); err != nil { t.Fatal(err) }
+		}
+		if _, err := writeAtomic(t, name, "a{}").Commit(); err != nil { t.Fatal(err) }
+		fi, err := os.Stat(name)
+		if err != nil { t.Fatal(err) }
+		if p := fi.Permission(); p != test.want { t.Errorf("%s: permissions %o, want %o", test.name, p, test.want) }
+	}
+}
+
+// a link stays, the file it points to is replaced
+func TestAtomicFileLink(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "target.css")
+	link := filepath.Join(dir, "link.css")
+	writeFile(t, target, "old")
+	if err := os.Symlink(target, link); err != nil { t.Fatal(err) }
+	if _, err := writeAtomic(t, link, "a{}").Commit(); err != nil { t.Fatal(err) }
+	if s := readFile(t, target); s != "a{}" { t.Errorf("target is %q", s) }
+	if to, err := os.Readlink(link); err != nil || to != target { t.Errorf("link to %q, %v", to, err) }
+}
+
+// temporary files are removed when gocss is killed
+func TestRemoveTemps(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	f := writeAtomic(t, filepath.Join(dir, "a-c.css"), "a{}")
+	removeTemps()
+	f.File.Close()
+	f.forget()
+	if extra := leftovers(t, dir); extra != nil { t.Errorf("left %v", extra) }
+}
+
+// outputs that come out the same aren't written again
+func TestUnchangedOutputs(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "a.css")
+	writeFile(t, in, "a { float: left }")
+	job := &Job{Name: in, Sources: []string{in}, Compressed: filepath.Join(dir, "a-c.css"), RTL: filepath.Join(dir, "a-rtl-c.css"), Comments: true}
+	quietly(func() {
+		if _, err := processFile(job, 0, ioutil.Discard); err != nil { t.Fatal(err) }
+		stamps := []stamp{stampOf(job.Compressed), stampOf(job.RTL)}
+		writeFile(t, in, "a { float: left; }")
+		if _, err := processFile(job, 0, ioutil.Discard); err != nil { t.Fatal(err) }
+		if stampOf(job.Compressed) != stamps[0] || stampOf(job.RTL) != stamps[1] { t.Errorf("outputs written again") }
+	})
+}
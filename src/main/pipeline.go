@@ -0,0 +1,180 @@
+// Stages between the stylesheet read and the files written, shared by file
+// and stdin mode
+package main
+
+import (
+	"./ast"
+	"./beautify"
+	"./diag"
+	"./lexer"
+	"./parser"
+	"./restructure"
+	"./rtl"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Files the outputs of a job go to, those left nil aren't written
+type Outputs struct {
+	Compressed   *os.File
+	Generated    *os.File
+	RTLGenerated *os.File
+	RTL          *os.File
+}
+
+// Compresses the stylesheet read from r, recorded in src, with the stages
+// job and the outputs need, or beautifies it with -b. Diagnostics are written
+// to diagnostics and progress to log, when it isn't nil. Returns the first
+// read and write errors.
+func runPipeline(job *Job, src *lexer.Source, r io.Reader, out *Outputs, diagnostics, log io.Writer, threadNum int) (read, write os.Error) {
+	progress := func(what, name string) {
+		if *verbose && log != nil { fmt.Fprintf(log, "[%d] %s: %s\n", threadNum, what, name) }
+	}
+
+	reporter := diag.CreateReporter(src, diagnostics)
+
+	lex := lexer.CreateReader(r)
+	lex.Source = src
+
+	// stages other than the compressor run in goroutines of their own and
+	// read the tokens from a channel
+	var tokenValues chan(lexer.TokenValue)
+	if *concurrent || *pretty || job.Lower || job.Flip || job.BidiPrefix != "" ||
+			out.Compressed == nil || out.Generated != nil || out.RTLGenerated != nil || out.RTL != nil {
+		go lex.Run()
+		tokenValues = lex.Out
+	}
+
+	// flipping the left to right properties gives the right to left ones
+	if job.Lower {
+		logical, lowered := rtl.CreateLogical(tokenValues, rtl.LeftToRight)
+		go logical.Run()
+		tokenValues = lowered
+	}
+
+	// end of file signals and errors of the extra outputs
+	var waits []chan(int)
+	var errs []*os.Error
+	// compressed outputs restructured once they are complete, when rules
+	// are merged or declarations overridden
+	var merges []*restructure.Writer
+	compressor := func(w io.Writer) io.Writer {
+		if !job.Merge && !job.Override { return w }
+		m := restructure.CreateWriter(w, job.Merge, job.Override)
+		merges = append(merges, m)
+		return m
+	}
+
+	if out.Generated != nil {
+		progress("Generating", job.Generated)
+
+		tee, teed := CreateTokenValueFileStreamer(tokenValues, out.Generated)
+		go tee.Run()
+		tokenValues = teed
+		errs = append(errs, &tee.Err)
+	}
+
+	if out.RTLGenerated != nil {
+		progress("Converting", job.RTLGenerated)
+
+		// split channels
+		gensplitter, out1, out2 := CreateChannelSplitter(tokenValues)
+		go gensplitter.Run()
+		genconverter, geneof := rtl.CreateConverter(out2, out.RTLGenerated)
+		go genconverter.Run()
+		tokenValues = out1
+		waits = append(waits, geneof)
+		errs = append(errs, &genconverter.Err)
+	}
+
+	if out.RTL != nil {
+		progress("Converting", job.RTL)
+
+		// split channels, the flipped tokens get a compressor of their own
+		splitter, out3, out4 := CreateChannelSplitter(tokenValues)
+		go splitter.Run()
+		flipper, flipped := rtl.CreateFlipper(out4)
+		go flipper.Run()
+		rtleof := make(chan(int))
+		if *pretty {
+			err := new(os.Error)
+			go func() {
+				*err = prettyPrint(flipped, out.RTL, nil)
+				rtleof <- 1
+			}()
+			errs = append(errs, err)
+		} else {
+			rtlParser := parser.CreateWriter(compressor(out.RTL), job.Yui)
+			rtlParser.In = flipped
+			rtlParser.NoComments = !job.Comments
+			rtlParser.Precision = *precision
+			rtlParser.Eof = rtleof
+			go rtlParser.Run()
+			errs = append(errs, &rtlParser.Err)
+		}
+		tokenValues = out3
+		waits = append(waits, rtleof)
+	}
+
+	if job.Flip {
+		flipper, flipped := rtl.CreateFlipper(tokenValues)
+		go flipper.Run()
+		tokenValues = flipped
+	}
+
+	if job.BidiPrefix != "" {
+		splitter, split := rtl.CreateBidi(tokenValues, job.BidiPrefix)
+		go splitter.Run()
+		tokenValues = split
+	}
+
+	if out.Compressed != nil && *pretty {
+		progress("Beautifying", job.Compressed)
+
+		err := prettyPrint(tokenValues, out.Compressed, reporter)
+		errs = append([]*os.Error{&err}, errs...)
+	} else if out.Compressed != nil {
+		progress("Compressing", job.Compressed)
+
+		parser := parser.CreateWriter(compressor(out.Compressed), job.Yui)
+		parser.Diag = reporter
+		parser.NoComments = !job.Comments
+		parser.Precision = *precision
+		parser.Parse(tokens(lex, tokenValues))
+		errs = append([]*os.Error{&parser.Err}, errs...)
+	} else {
+		// nothing is compressed, the other outputs still need the tokens
+		for tv := <- tokenValues; tv.Token != lexer.EndToken; tv = <- tokenValues {
+		}
+	}
+
+	// wait for the other outputs to finish
+	for _, w := range waits {
+		<- w
+	}
+
+	for _, m := range merges {
+		if err := m.Close(); err != nil { errs = append(errs, &err) }
+	}
+
+	for _, e := range errs {
+		if *e != nil && write == nil { write = *e }
+	}
+	return lex.Err, write
+}
+
+// beautifies the stylesheet, after the stages that change it
+func prettyPrint(tokenValues chan(lexer.TokenValue), w io.Writer, reporter *diag.Reporter) os.Error {
+	builder := ast.CreateBuilder(tokenValues)
+	builder.Diag = reporter
+	builder.Comments = true
+	return beautify.Print(builder.Build(), w, *indent)
+}
+
+// The compressor pulls tokens from the lexer directly, unless they are sent
+// to a channel for concurrent stages
+func tokens(lex *lexer.Lexer, tokenValues chan(lexer.TokenValue)) lexer.TokenReader {
+	if tokenValues == nil { return lex }
+	return lexer.Channel(tokenValues)
+}
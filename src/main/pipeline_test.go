@@ -0,0 +1,188 @@
+package main
+
+import (
+	"./lexer"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var OUTPUTS = []string{"compressed", "generated", "rtl-generated", "rtl"}
+
+// Runs the pipeline of job on css with the outputs named in which, out of
+// OUTPUTS, and returns what was written to each of them
+func runJob(t *testing.T, job *Job, css string, which ...string) map[string] string {
+	files := make([]*os.File, len(OUTPUTS))
+	for _, name := range which {
+		for i, o := range OUTPUTS {
+			if o != name { continue }
+			f, err := ioutil.TempFile("", "gocss-test")
+			if err != nil { t.Fatal(err) }
+			defer os.Remove(f.Name())
+			defer f.Close()
+			files[i] = f
+		}
+	}
+	var diagnostics bytes.Buffer
+	out := &Outputs{files[0], files[1], files[2], files[3]}
+	read, write := runPipeline(job, lexer.CreateSource(""), strings.NewReader(css), out, &diagnostics, nil, 0)
+	if read != nil || write != nil { t.Fatalf("%q: %v %v", css, read, write) }
+
+	written := make(map[string] string)
+	for i, f := range files {
+		if f == nil { continue }
+		data, err := ioutil.ReadFile(f.Name())
+		if err != nil { t.Fatal(err) }
+		written[OUTPUTS[i]] = string(data)
+	}
+	return written
+}
+
+// the right to left output is compressed like the normal one
+var rtlTests = []struct {
+	yui          bool
+	in, out, rtl string
+}{
+	{false, "a { float: left; margin: 0 1px 0 2px }", "a{float:left;margin:0 1px 0 2px}", "a{float:right;margin:0 2px 0 1px}"},
+	{false, "/*! keep */\n.left { padding-left: 10px; color: #ff0000 }", "/*! keep */.left{padding-left:10px;color:red}", "/*! keep */.left{padding-right:10px;color:red}"},
+	{false, "@media screen { a { text-align: left } }", "@media screen{a{text-align:left}}", "@media screen{a{text-align:right}}"},
+	{true, "a { background-position: 20% 0; cursor: e-resize }", "a{background-position:20% 0;cursor:e-resize}", "a{background-position:80% 0;cursor:w-resize}"},
+}
+
+func TestRTLOutput(t *testing.T) {
+	for _, test := range rtlTests {
+		out := runJob(t, &Job{Yui: test.yui, Comments: true}, test.in, "compressed", "rtl")
+		if out["compressed"] != test.out || out["rtl"] != test.rtl {
+			t.Errorf("%q: compressed to %q and %q, want %q and %q", test.in, out["compressed"], out["rtl"], test.out, test.rtl)
+		}
+	}
+}
+
+// without a compressed output, the right to left one still is
+func TestRTLOnly(t *testing.T) {
+	out := runJob(t, &Job{Comments: true}, "a { float: left }", "rtl", "rtl-generated")
+	if out["rtl"] != "a{float:right}" || out["rtl-generated"] != "a { float: right }" {
+		t.Errorf("written %q", out)
+	}
+}
+
+// with -b every output that would be compressed is beautified, after the
+// stages that change it
+var prettyTests = []struct {
+	job   *Job
+	which []string
+	out   map[string] string
+}{
+	{&Job{}, []string{"compressed"}, map[string] string{"compressed": "a {\n  float: left;\n  color: red;\n}\n"}},
+	{&Job{Flip: true}, []string{"compressed"}, map[string] string{"compressed": "a {\n  float: right;\n  color: red;\n}\n"}},
+	{&Job{BidiPrefix: "[dir=%s] "}, []string{"compressed"}, map[string] string{
+		"compressed": "a {\n  color: red;\n}\n\n[dir=ltr] a {\n  float: left;\n}\n\n[dir=rtl] a {\n  float: right;\n}\n"}},
+	{&Job{}, []string{"compressed", "rtl"}, map[string] string{
+		"compressed": "a {\n  float: left;\n  color: red;\n}\n", "rtl": "a {\n  float: right;\n  color: red;\n}\n"}},
+	{&Job{}, []string{"rtl"}, map[string] string{"rtl": "a {\n  float: right;\n  color: red;\n}\n"}},
+	{&Job{Lower: true}, []string{"compressed", "rtl-generated"}, map[string] string{
+		"compressed": "a {\n  float: left;\n  color: red;\n}\n", "rtl-generated": "a { float: right; color: red }"}},
+}
+
+func TestPretty(t *testing.T) {
+	defer func(p bool) { *pretty = p }(*pretty)
+	*pretty = true
+	for _, test := range prettyTests {
+		out := runJob(t, test.job, "a { float: left; color: red }", test.which...)
+		for name, want := range test.out {
+			if out[name] != want { t.Errorf("%+v %s: %q, want %q", test.job, name, out[name], want) }
+		}
+	}
+}
+
+// diagnostics name the stylesheet, whichever writer reports them
+func TestDiagnosticsName(t *testing.T) {
+	defer func(p bool) { *pretty = p }(*pretty)
+	for _, p := range []bool{false, true} {
+		*pretty = p
+		var diagnostics bytes.Buffer
+		null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err != nil { t.Fatal(err) }
+		runPipeline(&Job{Flip: true}, lexer.CreateSource("in.css"), strings.NewReader("a { color: red; }}"), &Outputs{Compressed: null}, &diagnostics, nil, 0)
+		null.Close()
+		if s := diagnostics.String(); !strings.HasPrefix(s, "in.css:1:18: warning: ") { t.Errorf("-b %v: %q", p, s) }
+	}
+}
+
+// reader failing after the stylesheet it starts with
+type failing struct{ css string }
+
+func (f *failing) Read(p []byte) (n int, err os.Error) {
+	if f.css == "" { return 0, os.NewError("disk on fire") }
+	n = copy(p, f.css)
+	f.css = f.css[n:]
+	return
+}
+
+// a read error is returned as such, not as the end of the stylesheet
+func TestPipelineReadError(t *testing.T) {
+	for _, job := range []*Job{{}, {Yui: true}, {BidiPrefix: "[dir=%s] "}} {
+		read, write := runPipeline(job, lexer.CreateSource(""), &failing{"a { color: red }"}, &Outputs{}, ioutil.Discard, nil, 0)
+		if err := read; err == nil || err.String() != "disk on fire" || write != nil { t.Errorf("%+v: read %v, write %v", job, read, write) }
+	}
+}
+
+var bundle []byte
+
+// the stylesheets of testdata repeated to about 2 MB
+func corpus(b *testing.B) []byte {
+	if bundle != nil { return bundle }
+	files, err := filepath.Glob("../testdata/*.css")
+	if err != nil || len(files) == 0 { b.Fatal("no stylesheets in ../testdata") }
+	var buf bytes.Buffer
+	for buf.Len() < 2000000 {
+		for _, name := range files {
+			data, err := ioutil.ReadFile(name)
+			if err != nil { b.Fatal(err) }
+			buf.Write(data)
+			buf.WriteString("\n")
+		}
+	}
+	bundle = buf.Bytes()
+	return bundle
+}
+
+func benchmarkPipeline(b *testing.B, job *Job, parallel bool) {
+	b.StopTimer()
+	css := corpus(b)
+	null, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil { b.Fatal(err) }
+	defer null.Close()
+	defer func(p bool) { *concurrent = p }(*concurrent)
+	*concurrent = parallel
+	b.SetBytes(int64(len(css)))
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		runPipeline(job, lexer.CreateSource(""), bytes.NewBuffer(css), &Outputs{Compressed: null}, ioutil.Discard, nil, 0)
+	}
+}
+
+func BenchmarkPipeline(b *testing.B) {
+	benchmarkPipeline(b, &Job{Comments: true}, false)
+}
+
+// tokenizing and compressing in goroutines of their own, as with -p
+func BenchmarkPipelineConcurrent(b *testing.B) {
+	benchmarkPipeline(b, &Job{Comments: true}, true)
+}
+
+func BenchmarkPipelineYui(b *testing.B) {
+	benchmarkPipeline(b, &Job{Yui: true, Comments: true}, false)
+}
+
+// restructuring buffers the whole output and builds a model of it
+func BenchmarkPipelineMerge(b *testing.B) {
+	benchmarkPipeline(b, &Job{Merge: true, Comments: true}, false)
+}
+
+func BenchmarkPipelineOverride(b *testing.B) {
+	benchmarkPipeline(b, &Job{Override: true, Comments: true}, false)
+}
@@ -0,0 +1,170 @@
+// Rebuilding outputs when their inputs change
+package main
+
+import (
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// nanoseconds between checks of the watched files
+const POLL = 300e6
+// nanoseconds files have to stay the same before a rebuild starts
+const DEBOUNCE = 200e6
+
+// modification time and size of a watched file, the zero stamp is a file
+// that doesn't exist
+type stamp struct {
+	mtime int64
+	size  int64
+}
+
+func stampOf(name string) (s stamp) {
+	if fi, err := os.Stat(name); err == nil { s = stamp{fi.Mtime_ns, fi.Size} }
+	return
+}
+
+// Directories are stamped by the names in them, outputs written there don't
+// change the stamp after they have been created. Temporary files are hidden.
+func dirStamp(name string) (s stamp) {
+	d, err := os.Open(name)
+	if err != nil { return }
+	defer d.Close()
+	names, _ := d.Readdirnames(-1)
+	h := fnv.New64a()
+	for _, n := range names {
+		if n[0] == '.' { continue }
+		h.Write([]byte(n))
+		s.size++
+	}
+	s.mtime = int64(h.Sum64())
+	return
+}
+
+// Stamps of the sources of the jobs, their directories so new files are
+// noticed, the directories and lists they were found in, and the
+// configuration file when it is used
+func snapshot(jobs []*Job, watched map[string] bool) (stamps map[string] stamp) {
+	stamps = make(map[string] stamp)
+	for _, job := range jobs {
+		for _, name := range job.Sources {
+			stamps[name] = stampOf(name)
+			dir := filepath.Dir(name)
+			stamps[dir] = dirStamp(dir)
+		}
+	}
+	for name := range watched {
+		if fi, err := os.Stat(name); err == nil && fi.IsDirectory() {
+			stamps[name] = dirStamp(name)
+		} else {
+			stamps[name] = stampOf(name)
+		}
+	}
+	if flag.NArg() == 0 { stamps[*config] = stampOf(*config) }
+	return
+}
+
+func changed(a, b map[string] stamp) (names map[string] bool) {
+	names = make(map[string] bool)
+	for name, s := range a {
+		if t, ok := b[name]; !ok || s != t { names[name] = true }
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok { names[name] = true }
+	}
+	return
+}
+
+func isCancelled() bool {
+	select {
+	case <- cancel:
+		return true
+	default:
+	}
+	return false
+}
+
+// total size of the named files
+func size(names []string) (n int64) {
+	for _, name := range names {
+		n += stampOf(name).size
+	}
+	return
+}
+
+// Builds the jobs and prints a status line
+func rebuild(jobs []*Job, total int) {
+	start := time.Nanoseconds()
+	failed := convertArgs(jobs)
+	ms := (time.Nanoseconds() - start) / 1e6
+
+	var in, out int64
+	for _, job := range jobs {
+		in += size(job.Sources)
+		out += size([]string{job.Compressed})
+	}
+	status := fmt.Sprintf("%s built %d of %d in %dms, %d -> %d bytes",
+		time.LocalTime().Format("15:04:05"), len(jobs), total, ms, in, out)
+	if in > 0 { status += fmt.Sprintf(" (%.1f%% smaller)", 100 - float64(out) * 100 / float64(in)) }
+	if failed > 0 { status += fmt.Sprintf(", %d failed", failed) }
+	fmt.Fprintf(os.Stderr, "%s\n", status)
+}
+
+// Builds every job, then polls their inputs until interrupted. Jobs are
+// rebuilt when one of their sources changes, and all of them are reloaded
+// when a directory, a list of files or the configuration file does.
+func watch(jobs []*Job, watched map[string] bool) {
+	rebuild(jobs, len(jobs))
+	stamps := snapshot(jobs, watched)
+	for !isCancelled() {
+		time.Sleep(POLL)
+		current := snapshot(jobs, watched)
+		if len(changed(stamps, current)) == 0 { continue }
+
+		// wait for the files to settle, editors write in several steps
+		for {
+			time.Sleep(DEBOUNCE)
+			next := snapshot(jobs, watched)
+			if len(changed(current, next)) == 0 { break }
+			current = next
+		}
+		names := changed(stamps, current)
+
+		// new files, removed files or new settings
+		reload := false
+		for name := range names {
+			if fi, err := os.Stat(name); err == nil && fi.IsDirectory() || name == *config || watched[name] { reload = true }
+		}
+		if reload {
+			loaded, w, err := loadJobs()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s\n", err)
+			} else {
+				// sources of the new jobs count as changed when they are new
+				for name := range snapshot(loaded, w) {
+					if _, ok := stamps[name]; !ok { names[name] = true }
+				}
+				jobs, watched = loaded, w
+			}
+		}
+
+		// everything may have changed with the configuration
+		affected := jobs
+		if !names[*config] || flag.NArg() > 0 {
+			affected = nil
+			for _, job := range jobs {
+				for _, name := range job.Sources {
+					if names[name] {
+						affected = append(affected, job)
+						break
+					}
+				}
+			}
+		}
+		stamps = snapshot(jobs, watched)
+		if len(affected) > 0 && !isCancelled() { rebuild(affected, len(jobs)) }
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// the names set in m, sorted
+func names(m map[string] bool) string {
+	var s []string
+	for name, ok := range m {
+		if ok { s = append(s, name) }
+	}
+	sort.Strings(s)
+	return strings.Join(s, " ")
+}
+
+var changedTests = []struct {
+	a, b map[string] stamp
+	want string
+}{
+	{map[string] stamp{}, map[string] stamp{}, ""},
+	{map[string] stamp{"a": {1, 2}}, map[string] stamp{"a": {1, 2}}, ""},
+	{map[string] stamp{"a": {1, 2}}, map[string] stamp{"a": {1, 3}}, "a"},
+	{map[string] stamp{"a": {1, 2}}, map[string] stamp{"a": {2, 2}}, "a"},
+	// created and removed files
+	{map[string] stamp{"a": {1, 2}}, map[string] stamp{"a": {1, 2}, "b": {}}, "b"},
+	{map[string] stamp{"a": {1, 2}, "b": {1, 1}}, map[string] stamp{"b": {1, 1}}, "a"},
+	{map[string] stamp{"a": {1, 2}, "b": {1, 1}}, map[string] stamp{"a": {}, "b": {3, 1}}, "a b"},
+}
+
+func TestChanged(t *testing.T) {
+	for _, test := range changedTests {
+		if got := names(changed(test.a, test.b)); got != test.want { t.Errorf("%v to %v: %q, want %q", test.a, test.b, got, test.want) }
+	}
+}
+
+// directories change with the names in them, not with what is written to
+// the files or to hidden ones
+func TestDirStamp(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	writeFile(t, filepath.Join(dir, "a.css"), "a{}")
+	s := dirStamp(dir)
+	for _, step := range []struct{ name string; same bool }{
+		{"a.css", true},
+		{".a-c.css.123", true},
+		{"b.css", false},
+	} {
+		writeFile(t, filepath.Join(dir, step.name), "b{}")
+		next := dirStamp(dir)
+		if (next == s) != step.same { t.Errorf("%s: stamp %v, was %v", step.name, next, s) }
+		s = next
+	}
+	if dirStamp(filepath.Join(dir, "missing")) != (stamp{}) { t.Errorf("missing directory stamped") }
+}
+
+// sources, their directories and the configuration are watched
+func TestSnapshot(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	a, b := filepath.Join(dir, "a.css"), filepath.Join(dir, "css", "b.css")
+	writeFile(t, a, "a{}")
+	writeFile(t, b, "b{}")
+	jobs := []*Job{{Sources: []string{a}}, {Sources: []string{a, b}}}
+
+	stamps := snapshot(jobs, nil)
+	want := []string{a, b, dir, filepath.Join(dir, "css"), *config}
+	if len(stamps) != len(want) { t.Errorf("%d stamps, want %d: %v", len(stamps), len(want), stamps) }
+	for _, name := range want {
+		if _, ok := stamps[name]; !ok { t.Errorf("%s isn't watched", name) }
+	}
+
+	writeFile(t, b, "b { color: red }")
+	if got := names(changed(stamps, snapshot(jobs, nil))); got != b { t.Errorf("changed %q, want %s", got, b) }
+	os.Remove(a)
+	if got := names(changed(stamps, snapshot(jobs, nil))); got != dir + " " + a + " " + b { t.Errorf("changed %q", got) }
+}
+
+// files created in watched directories and changed lists are noticed
+func TestSnapshotWatched(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	sub, list := filepath.Join(dir, "css", "new"), filepath.Join(dir, "list.txt")
+	writeFile(t, filepath.Join(sub, "a.css"), "a{}")
+	writeFile(t, list, "a.css\n")
+	watched := map[string] bool{sub: true, list: true}
+	stamps := snapshot(nil, watched)
+	writeFile(t, filepath.Join(sub, "b.css"), "b{}")
+	writeFile(t, list, "a.css\nb.css\n")
+	if got := names(changed(stamps, snapshot(nil, watched))); got != sub + " " + list { t.Errorf("changed %q", got) }
+}
+
+// a rebuild of a single job leaves the number of threads alone
+func TestRebuildThreads(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "a.css")
+	writeFile(t, in, "a{}")
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	quietly(func() { convertArgs([]*Job{{Name: in, Sources: []string{in}, Compressed: in + ".min"}}) })
+	if n := runtime.GOMAXPROCS(0); n != 4 { t.Errorf("%d threads, were 4", n) }
+}
@@ -0,0 +1,75 @@
+// Minifier for programs that embed gocss
+package minify
+
+import (
+	"./lexer"
+	"./parser"
+	"./restructure"
+	"./rtl"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+)
+
+type Options struct {
+	// match output to YUI Compressor v2.4.6
+	Yui        bool
+	// flip for right to left languages
+	RTL        bool
+	// replace logical properties with physical ones
+	Lower      bool
+	// drop /*! */ comments as well
+	NoComments bool
+	// digits numbers are rounded to after the point, like -precision. Nil or
+	// negative keeps them all.
+	Precision  *int
+	// merge duplicate and adjacent rules
+	Merge      bool
+	// drop declarations overridden in the same block
+	Override   bool
+}
+
+// Compresses the stylesheet read from r into w. Every call has a pipeline
+// of its own, so calls may run concurrently.
+func Minify(r io.Reader, w io.Writer, opts Options) (err os.Error) {
+	lex := lexer.CreateReader(r)
+	var in lexer.TokenReader = lex
+
+	// the optional stages run in goroutines of their own
+	tokenValues := lex.Out
+	if opts.Lower || opts.RTL { go lex.Run() }
+	if opts.Lower {
+		logical, out := rtl.CreateLogical(tokenValues, rtl.LeftToRight)
+		go logical.Run()
+		tokenValues = out
+	}
+	if opts.RTL {
+		flipper, flipped := rtl.CreateFlipper(tokenValues)
+		go flipper.Run()
+		tokenValues = flipped
+	}
+
+	if tokenValues != lex.Out { in = lexer.Channel(tokenValues) }
+
+	var m *restructure.Writer
+	if opts.Merge || opts.Override {
+		m = restructure.CreateWriter(w, opts.Merge, opts.Override)
+		w = m
+	}
+
+	// the input is read to the end after a failed write so the stages end
+	p := parser.CreateWriter(w, opts.Yui)
+	p.NoComments = opts.NoComments
+	if opts.Precision != nil { p.Precision = *opts.Precision }
+	err = p.Parse(in)
+	if m != nil && err == nil { err = m.Close() }
+	if lex.Err != nil { err = lex.Err }
+	return
+}
+
+func MinifyString(s string, opts Options) (string, os.Error) {
+	var out bytes.Buffer
+	err := Minify(strings.NewReader(s), &out, opts)
+	return out.String(), err
+}
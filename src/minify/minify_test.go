@@ -0,0 +1,98 @@
+package minify
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+var minifyTests = []struct {
+	in   string
+	opts Options
+	out  string
+}{
+	{"a { color : #ff0000 ; margin:0px }", Options{}, "a{color:red;margin:0}"},
+	{"/*! c */ a{b:c}", Options{}, "/*! c */a{b:c}"},
+	{"/*! c */ a{b:c}", Options{NoComments: true}, "a{b:c}"},
+	{"a{float:left;padding:1px 2px 3px 4px}", Options{RTL: true}, "a{float:right;padding:1px 4px 3px 2px}"},
+	{"a{margin-inline:1px 2px}", Options{Lower: true}, "a{margin-left:1px;margin-right:2px}"},
+	{"a{float:left;margin-inline-start:1px}", Options{RTL: true, Lower: true}, "a{float:right;margin-right:1px}"},
+	{"a{color:red;color:blue}", Options{Yui: true}, "a{color:red;color:blue}"},
+	{"a{color:red;color:blue}", Options{}, "a{color:red;color:#00f}"},
+	{"a{color:red;color:blue}", Options{Override: true}, "a{color:#00f}"},
+	{"a{color:red;color:blue}", Options{Yui: true, Override: true}, "a{color:blue}"},
+	{"a{color:red}b{color:red}", Options{Merge: true}, "a,b{color:red}"},
+	{"", Options{}, ""},
+	{"a{width:1.256px}", Options{}, "a{width:1.256px}"},
+	{"a{width:1.256px}", Options{Precision: digits(2)}, "a{width:1.26px}"},
+	{"a{width:1.256px}", Options{Precision: digits(0)}, "a{width:1px}"},
+	{"a{width:1.256px}", Options{Precision: digits(-1)}, "a{width:1.256px}"},
+}
+
+func digits(n int) *int {
+	return &n
+}
+
+func TestMinify(t *testing.T) {
+	for _, test := range minifyTests {
+		out, err := MinifyString(test.in, test.opts)
+		if err != nil || out != test.out {
+			t.Errorf("%q %+v: minified to %q, %v, want %q", test.in, test.opts, out, err, test.out)
+		}
+	}
+}
+
+// every call has a pipeline of its own
+func TestMinifyConcurrent(t *testing.T) {
+	want := make([]string, len(minifyTests))
+	for i, test := range minifyTests {
+		want[i], _ = MinifyString(strings.Repeat(test.in, 100), test.opts)
+	}
+	done := make(chan(bool))
+	for i := 0; i < 4 * len(minifyTests); i++ {
+		go func(i int) {
+			test := minifyTests[i]
+			if out, err := MinifyString(strings.Repeat(test.in, 100), test.opts); err != nil || out != want[i] {
+				t.Errorf("%q %+v: minified to %q, %v", test.in, test.opts, out, err)
+			}
+			done <- true
+		}(i % len(minifyTests))
+	}
+	for i := 0; i < 4 * len(minifyTests); i++ {
+		<- done
+	}
+}
+
+type failing struct {
+	r io.Reader
+	n int
+}
+
+// fails after n bytes
+func (f *failing) Read(p []byte) (int, os.Error) {
+	if f.n <= 0 { return 0, os.NewError("read failed") }
+	if len(p) > f.n { p = p[:f.n] }
+	n, err := f.r.Read(p)
+	f.n -= n
+	return n, err
+}
+
+func (f *failing) Write(p []byte) (int, os.Error) {
+	return 0, os.NewError("write failed")
+}
+
+func TestMinifyErrors(t *testing.T) {
+	for _, opts := range []Options{{}, {Yui: true}, {RTL: true, Lower: true}} {
+		var out bytes.Buffer
+		err := Minify(&failing{strings.NewReader("a{b:c}d{e:f}"), 5}, &out, opts)
+		if err == nil || err.String() != "read failed" {
+			t.Errorf("%+v: read error %v", opts, err)
+		}
+		err = Minify(strings.NewReader(strings.Repeat("a{b:c}", 10000)), &failing{}, opts)
+		if err == nil || err.String() != "write failed" {
+			t.Errorf("%+v: write error %v", opts, err)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Shortest forms of numbers and their units
+package parser
+
+import (
+	"./lexer"
+	"strconv"
+	"strings"
+)
+
+var (
+	// units of lengths, a length of zero is the same in every one of them
+	LENGTHS          = map[string] bool {
+		"px": true, "em": true, "rem": true, "ex": true, "rex": true, "ch": true, "rch": true,
+		"cap": true, "rcap": true, "ic": true, "ric": true, "lh": true, "rlh": true,
+		"cm": true, "mm": true, "q": true, "in": true, "pt": true, "pc": true,
+		"vw": true, "vh": true, "vi": true, "vb": true, "vmin": true, "vmax": true,
+		"svw": true, "svh": true, "lvw": true, "lvh": true, "dvw": true, "dvh": true,
+		"cqw": true, "cqh": true, "cqi": true, "cqb": true, "cqmin": true, "cqmax": true,
+	}
+	// properties that take a length where a zero may be written without
+	// its unit, along with those starting with one of ZERO_PREFIXES and a dash
+	ZERO_LENGTHS     = map[string] bool {
+		"top": true, "right": true, "bottom": true, "left": true,
+		"width": true, "height": true, "min-width": true, "min-height": true, "max-width": true, "max-height": true,
+		"inline-size": true, "block-size": true, "min-inline-size": true, "min-block-size": true,
+		"max-inline-size": true, "max-block-size": true,
+		"letter-spacing": true, "word-spacing": true, "text-indent": true, "vertical-align": true, "font-size": true,
+		"background": true, "background-position": true, "background-position-x": true,
+		"background-position-y": true, "background-size": true,
+		"box-shadow": true, "text-shadow": true, "transform": true, "transform-origin": true,
+		"perspective-origin": true, "clip": true, "shape-margin": true,
+		"gap": true, "row-gap": true, "column-gap": true, "grid-gap": true,
+	}
+	ZERO_PREFIXES    = []string{"margin", "padding", "border", "outline", "inset", "scroll-margin", "scroll-padding", "column-rule"}
+	// properties where 0% is the same as 0, percentages of sizes that may be
+	// indefinite are not
+	ZERO_PERCENTAGES = map[string] bool {
+		"margin": true, "margin-top": true, "margin-right": true, "margin-bottom": true, "margin-left": true,
+		"padding": true, "padding-top": true, "padding-right": true, "padding-bottom": true, "padding-left": true,
+		"background": true, "background-position": true, "background-position-x": true,
+		"background-position-y": true, "background-size": true, "border-radius": true,
+		"transform-origin": true, "perspective-origin": true, "text-indent": true,
+	}
+	// functions whose lengths may be a unitless zero
+	ZERO_FUNCTIONS   = map[string] bool {
+		"translate(": true, "translatex(": true, "translatey(": true, "translatez(": true,
+		"translate3d(": true, "rect(": true,
+	}
+	// units of the same quantity, by how many of them make the first one
+	TIMES            = []unit{{"s", 1}, {"ms", 1000}}
+	ANGLES           = []unit{{"turn", 1}, {"deg", 360}, {"grad", 400}}
+)
+
+type unit struct {
+	name string
+	per  float64
+}
+
+// Writes a number without a plus sign, leading and trailing zeros, rounded
+// to precision digits after the point unless precision is negative.
+// Numbers with an exponent are kept as they are.
+func formatNumber(s string, precision int) string {
+	sign := ""
+	n := s
+	if n != "" && (n[0] == '+' || n[0] == '-') {
+		if n[0] == '-' { sign = "-" }
+		n = n[1:]
+	}
+	digits, fraction := n, ""
+	if i := strings.Index(n, "."); i >= 0 { digits, fraction = n[:i], n[i+1:] }
+	for _, c := range digits + fraction {
+		if c < '0' || c > '9' { return s }
+	}
+
+	if precision >= 0 && len(fraction) > precision {
+		up := fraction[precision] >= '5'
+		all := []byte(digits + fraction[:precision])
+		for i := len(all) - 1; up && i >= 0; i-- {
+			if all[i] == '9' {
+				all[i] = '0'
+			} else {
+				all[i]++
+				up = false
+			}
+		}
+		if up { all = append([]byte{'1'}, all...) }
+		digits, fraction = string(all[:len(all)-precision]), string(all[len(all)-precision:])
+	}
+
+	digits = strings.TrimLeft(digits, "0")
+	fraction = strings.TrimRight(fraction, "0")
+	switch {
+	case digits == "" && fraction == "":
+		// -0 is 0
+		return "0"
+	case fraction == "":
+		return sign + digits
+	}
+	return sign + digits + "." + fraction
+}
+
+// The number n written in the shortest of units, ok is false when unit isn't
+// one of them. Only conversions that give back the same number are used.
+func convert(n, unit string, units []unit) (s string, ok bool) {
+	var from float64
+	for _, u := range units {
+		if u.name == unit { from, ok = u.per, true }
+	}
+	if !ok { return }
+	v, err := strconv.Atof64(n)
+	if err != nil { return n + unit, true }
+
+	s = n + unit
+	for _, u := range units {
+		if u.name == unit { continue }
+		w := v / from * u.per
+		c := formatNumber(strconv.Ftoa64(w, 'f', -1), -1)
+		if back, err := strconv.Atof64(c); err == nil && back / u.per * from == v && len(c + u.name) < len(s) {
+			s = c + u.name
+		}
+	}
+	return
+}
+
+// strips a vendor prefix
+func unprefixed(property string) string {
+	if len(property) > 1 && property[0] == '-' && property[1] != '-' {
+		if i := strings.Index(property[1:], "-"); i >= 0 { return property[i+2:] }
+	}
+	return property
+}
+
+// True when a zero with unit may be written without it in the current
+// declaration and function
+func (p *Parser) unitlessZero(unit string) bool {
+	for _, f := range p.functions {
+		if !ZERO_FUNCTIONS[f] { return false }
+	}
+	property := unprefixed(p.property)
+	if unit == "%" { return ZERO_PERCENTAGES[property] }
+	if !LENGTHS[unit] { return false }
+	if ZERO_LENGTHS[property] { return true }
+	for _, prefix := range ZERO_PREFIXES {
+		if property == prefix || strings.HasPrefix(property, prefix + "-") { return true }
+	}
+	return false
+}
+
+// Shortest form of a number, percentage or dimension in a declaration.
+// Custom properties are kept as they are.
+func (p *Parser) normalize(tv lexer.TokenValue) string {
+	if p.property == ZERO_STR || strings.HasPrefix(p.property, "--") { return tv.Value }
+	n := formatNumber(number(tv), p.Precision)
+	unit := ""
+	switch tv.Token {
+	case lexer.Percentage:
+		unit = "%"
+	case lexer.Dimension:
+		unit = strings.ToLower(tv.Unit)
+	}
+	// escaped units are kept as they are
+	if strings.Index(unit, "\\") >= 0 { return n + tv.Unit }
+
+	if n == "0" && unit != "" && p.unitlessZero(unit) { return n }
+	if s, ok := convert(n, unit, TIMES); ok { return s }
+	if s, ok := convert(n, unit, ANGLES); ok { return s }
+	return n + unit
+}
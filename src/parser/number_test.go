@@ -0,0 +1,99 @@
+package parser
+
+import (
+	"./lexer"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var formatTests = []struct {
+	in        string
+	precision int
+	out       string
+}{
+	{"1.50", -1, "1.5"},
+	{"0.5", -1, ".5"},
+	{"+.5", -1, ".5"},
+	{"-0", -1, "0"},
+	{"-0.0", -1, "0"},
+	{"-0.50", -1, "-.5"},
+	{"010", -1, "10"},
+	{"10", -1, "10"},
+	{"1e3", -1, "1e3"},
+	{"1.256", 2, "1.26"},
+	{"1.254", 2, "1.25"},
+	{"1.5", 0, "2"},
+	{"1.4", 0, "1"},
+	{"9.99", 1, "10"},
+	{"-0.004", 2, "0"},
+	{"0.996", 2, "1"},
+	{"12", 2, "12"},
+}
+
+func TestFormatNumber(t *testing.T) {
+	for _, test := range formatTests {
+		if out := formatNumber(test.in, test.precision); out != test.out { t.Errorf("%q to %d digits: %q, want %q", test.in, test.precision, out, test.out) }
+	}
+}
+
+// only conversions that give back the same number
+var convertTests = []struct{ n, unit, out string }{
+	{"500", "ms", ".5s"},
+	{"1500", "ms", "1.5s"},
+	{"50", "ms", "50ms"},
+	{"1", "ms", "1ms"},
+	{"2", "s", "2s"},
+	{"90", "deg", "90deg"},
+	{"180", "deg", "180deg"},
+	{"360", "deg", "1turn"},
+	{"400", "grad", "1turn"},
+	{"1", "turn", "1turn"},
+}
+
+func TestConvert(t *testing.T) {
+	for _, test := range convertTests {
+		units := TIMES
+		if test.unit != "ms" && test.unit != "s" { units = ANGLES }
+		if out, ok := convert(test.n, test.unit, units); !ok || out != test.out { t.Errorf("%s%s: %q %v, want %q", test.n, test.unit, out, ok, test.out) }
+	}
+	if _, ok := convert("1", "px", TIMES); ok { t.Errorf("px converted to a time") }
+}
+
+// zero units are only dropped where a zero without one means the same,
+// YUI Compressor drops them everywhere and keeps other numbers as they are
+var numberTests = []compressTest{
+	{"a{margin:0px 0.50em}", "a{margin:0 .5em}", "a{margin:0 .50em}"},
+	{"a{width:0rem;height:0vh}", "a{width:0;height:0}", "a{width:0rem;height:0vh}"},
+	{"a{padding-left:-0px}", "a{padding-left:0}", "a{padding-left:-0px}"},
+	{"a{margin:0%}", "a{margin:0}", "a{margin:0}"},
+	{"a{width:0%}", "a{width:0%}", "a{width:0}"},
+	{"a{flex-basis:0px}", "a{flex-basis:0px}", "a{flex-basis:0}"},
+	{"a{flex:1 1 0px}", "a{flex:1 1 0px}", "a{flex:1 1 0}"},
+	{"a{line-height:0px}", "a{line-height:0px}", "a{line-height:0}"},
+	{"a{width:calc(0px + 1em)}", "a{width:calc(0px + 1em)}", "a{width:calc(0 + 1em)}"},
+	{"a{transform:translate(0px,0px)}", "a{transform:translate(0,0)}", "a{transform:translate(0,0)}"},
+	{"a{--x:0px;--y:0.50}", "a{--x:0px;--y:0.50}", "a{--x:0;--y:.50}"},
+	{"a{transition:opacity 500ms}", "a{transition:opacity .5s}", "a{transition:opacity 500ms}"},
+	{"a{transform:rotate(360deg)}", "a{transform:rotate(1turn)}", "a{transform:rotate(360deg)}"},
+	{"a{z-index:+1;opacity:1.0}", "a{z-index:1;opacity:1}", "a{z-index:+1;opacity:1.0}"},
+	{"a{width:0\\70 x}", "a{width:0\\70 x}", "a{width:0\\70 x}"},
+}
+
+func TestNumbers(t *testing.T) {
+	testCompress(t, numberTests)
+}
+
+func TestPrecision(t *testing.T) {
+	for _, test := range []struct{ precision int; out string }{
+		{-1, "a{width:1.256px;opacity:.333}"},
+		{2, "a{width:1.26px;opacity:.33}"},
+		{0, "a{width:1px;opacity:0}"},
+	} {
+		var b bytes.Buffer
+		p := CreateWriter(&b, false)
+		p.Precision = test.precision
+		p.Parse(lexer.CreateReader(strings.NewReader("a{width:1.256px;opacity:0.333}")))
+		if b.String() != test.out { t.Errorf("%d digits: %q, want %q", test.precision, b.String(), test.out) }
+	}
+}
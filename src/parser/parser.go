@@ -2,11 +2,14 @@
 package parser
 
 import (
+	"./color"
+	"./diag"
 	"./lexer"
 	"./sbuf"
+	"bufio"
+	"io"
+	"os"
 	"strings"
-	"strconv"
-	"fmt"
 )
 
 const MS_ALPHA = "progid:dximagetransform.microsoft.alpha(opacity="
@@ -43,6 +46,7 @@ var (
 		lexer.Comment:    true,
 		lexer.LeftParen:  true,
 		lexer.RightParen: true,
+		lexer.Function:   true,
 		lexer.Match:      true,
 	}
 	NONE_PROPERTIES = make(map[string]bool, 15)
@@ -72,36 +76,71 @@ func init() {
 
 type Parser struct {
 	In          chan(lexer.TokenValue)
+	// output goes to W when it is set, otherwise to Out
+	W           *bufio.Writer
 	Out         chan(string)
+	// signalled by Run when it is done
+	Eof         chan(int)
 	Yui         bool
+	// drop /*! */ comments as well
+	NoComments  bool
+	// digits numbers are rounded to after the point, -1 keeps them all
+	Precision   int
+	// when set, problems in the input are reported there
+	Diag        *diag.Reporter
 	lastToken   lexer.Token
 	lastValue   string
 	property    string
 	ruleBuffer  sbuf.StringBuffer
 	valueBuffer sbuf.StringBuffer
-	rgbBuffer   sbuf.StringBuffer
+	// tokens of a color function up to its closing parenthesis
+	color       []lexer.TokenValue
+	replaying   bool
+	// functions the current token is in, lower case with their parenthesis
+	functions   []string
 	pending     string
+	atRule      lexer.TokenValue
+	depth       int
 	inRule      bool
 	space       bool
 	charset     bool
 	at          bool
 	ie5mac      bool
 	ie5macOn    bool
-	rgb         bool
 	rgba        bool
 	checkSpace  int
+	// first error writing to W
+	Err         os.Error
 }
 
 func CreateParser(in chan(lexer.TokenValue), yui bool) (parser *Parser, out chan(string)) {
 	out = make(chan(string))
-	parser = &Parser{In: in, Out: out, Yui: yui}
+	parser = &Parser{In: in, Out: out, Yui: yui, Precision: -1}
 	return
 }
 
+// Parser writing to w, tokens are passed to Parse or read from In by Run
+func CreateWriter(w io.Writer, yui bool) (parser *Parser) {
+	parser = &Parser{W: bufio.NewWriter(w), Yui: yui, Precision: -1}
+	return
+}
+
+func (p *Parser) emit(s string) {
+	if p.W == nil {
+		p.Out <- s
+		return
+	}
+	if _, err := p.W.WriteString(s); err != nil && p.Err == nil { p.Err = err }
+}
+
+func (p *Parser) warning(tv lexer.TokenValue, format string, args ...interface{}) {
+	if p.Diag != nil { p.Diag.Warning(tv, format, args...) }
+}
+
 func (p *Parser) dump(str string) {
 	p.ruleBuffer.Push(p.pending)
 	p.ruleBuffer.Push(str)
-	p.Out <- p.ruleBuffer.Join("")
+	p.emit(p.ruleBuffer.Join(""))
 	p.ruleBuffer.Reset()
 	p.pending = ZERO_STR
 }
@@ -109,7 +148,7 @@ func (p *Parser) dump(str string) {
 func (p *Parser) write(str string) {
 	if len(str) == 0 { return }
 	if len(str) >= 3 && str[0:3] == "/*!" && p.ruleBuffer.Empty() {
-		p.Out <- str
+		p.emit(str)
 		return
 	}
 	p.ruleBuffer.Push(str)
@@ -117,7 +156,7 @@ func (p *Parser) write(str string) {
 		// check for empty rule
 		s := p.ruleBuffer.Join("")
 		nonempty := p.ruleBuffer.Len() == 1 || (len(s) >= 2 && s[len(s)-2:] != "{}")
-		if nonempty { p.Out <- s }
+		if nonempty { p.emit(s) }
 		p.ruleBuffer.Reset()
 		if !nonempty && p.ie5macOn {
 			// there is a starting ie5mac comment in the buffer, leave it there
@@ -149,55 +188,157 @@ func (p *Parser) q(str string) {
 	}
 }
 
-func (p *Parser) collapseZeroes() {
+// Writes the value of the declaration, shorthands in their shortest form
+func (p *Parser) collapse() {
 	t := p.valueBuffer.Join("")
 	p.valueBuffer.Reset()
 	switch {
-	case t == "0 0" || t == "0 0 0" || t == "0 0 0 0":
-		p.buffer("0")
-		if p.property == "background-position" || p.property == "-webkit-transform-origin" || p.property == "-moz-transform-origin" {
-			p.buffer(" 0")
-		}
 	case t == "none" && (p.property == "background" || in(NONE_PROPERTIES, p.property)):
 		p.buffer("0")
+	case p.Yui:
+		p.buffer(yuiZeroes(p.property, t))
 	default:
-		p.buffer(t)
+		p.buffer(shorthand(p.property, t))
 	}
 }
 
-func (p *Parser) token(token lexer.Token, value string) {
+// number part of a Dimension or Percentage
+func number(tv lexer.TokenValue) string {
+	switch tv.Token {
+	case lexer.Dimension:
+		return tv.Value[:len(tv.Value)-len(tv.Unit)]
+	case lexer.Percentage:
+		return tv.Value[:len(tv.Value)-1]
+	}
+	return tv.Value
+}
+
+func isNumeric(token lexer.Token) bool {
+	return token == lexer.Number || token == lexer.Dimension || token == lexer.Percentage
+}
+
+// problems in the input, every token is checked once
+func (p *Parser) check(tv lexer.TokenValue) {
+	token, value := tv.Token, tv.Value
+	switch {
+	case token == lexer.BadString:
+		p.warning(tv, "unterminated string")
+	case token == lexer.BadURL:
+		p.warning(tv, "malformed url")
+	case token == lexer.Comment && (len(value) < 4 || !strings.HasSuffix(value, "*/")):
+		p.warning(tv, "unterminated comment")
+	case token == lexer.LeftBrace:
+		p.depth++
+	case token == lexer.RightBrace:
+		if p.depth == 0 {
+			p.warning(tv, "unmatched }")
+		} else {
+			p.depth--
+		}
+	}
+}
+
+// Colors are rewritten in declarations other than custom properties and IE
+// filters, YUI Compressor only shortens rgb() and #aabbcc, anywhere
+func (p *Parser) colors() bool {
+	return !p.Yui && p.property != ZERO_STR && !strings.HasPrefix(p.property, "--") &&
+		p.property != "filter" && p.property != "-ms-filter"
+}
+
+// shortest form of a hex or named color, empty when there is none
+func (p *Parser) shortColor(tv lexer.TokenValue) string {
+	if !p.colors() { return ZERO_STR }
+	switch {
+	case tv.Token == lexer.Hash:
+		if s, ok := color.Shorten(tv.Value, true); ok { return s }
+	case tv.Token == lexer.Identifier && color.IsProperty(p.property):
+		if s, ok := color.Shorten(tv.Value, false); ok { return s }
+	}
+	return ZERO_STR
+}
+
+// Compresses tokens again without collecting color functions
+func (p *Parser) replay(tokens []lexer.TokenValue) {
+	p.color = nil
+	p.replaying = true
+	for _, tv := range tokens {
+		p.token(tv)
+	}
+	p.replaying = false
+}
+
+// A color function is replaced by a hex or named color when one is the same,
+// otherwise it is compressed as it is
+func (p *Parser) colorFunction(tokens []lexer.TokenValue) {
+	var text sbuf.StringBuffer
+	for _, tv := range tokens {
+		if tv.Token == lexer.Whitespace || tv.Token == lexer.Comment {
+			text.Push(" ")
+		} else {
+			text.Push(tv.Value)
+		}
+	}
+	short, ok := color.Shorten(text.Join(""), false)
+	if p.Yui { short, ok = yuiColor(tokens, text.Join("")) }
+	if !ok {
+		p.replay(tokens)
+		return
+	}
+	token := lexer.Identifier
+	if short[0] == '#' { token = lexer.Hash }
+	p.replay([]lexer.TokenValue{lexer.TokenValue{Token: token, Value: short, Pos: tokens[0].Pos}})
+	// the closing parenthesis ended the function, the color needs a space
+	p.space = true
+}
+
+// YUI Compressor writes rgb() with whole numbers in hex, and nothing else
+func yuiColor(tokens []lexer.TokenValue, text string) (hex string, ok bool) {
+	for _, tv := range tokens[1:len(tokens)-1] {
+		switch tv.Token {
+		case lexer.Whitespace, lexer.Comment, lexer.Comma:
+		case lexer.Number:
+			if strings.Trim(tv.Value, "0123456789") != "" { return }
+		default:
+			return
+		}
+	}
+	c, ok := color.Parse(text)
+	if !ok { return }
+	return c.Hex()
+}
+
+func (p *Parser) token(tv lexer.TokenValue) {
+	token, value := tv.Token, tv.Value
 	//os.Stderr.WriteString("token: "+token.String()+", value: "+value+"\n")
 
-	if p.rgb {
+	// color functions are collected up to their closing parenthesis
+	if p.color != nil {
 		switch token {
-		case lexer.Number:
-			i, _ := strconv.Atoi(value) 
-			s := fmt.Sprintf("%x", i)
-			if len(s) == 1 { s = "0" + s }
-			p.rgbBuffer.Push(s)
-		case lexer.LeftParen:
-			if p.lastToken == lexer.Number { p.q(" ") }
-			p.q("#")
-			p.rgbBuffer.Reset()
 		case lexer.RightParen:
-			if p.rgbBuffer.Len() == 3 {
-				a := p.rgbBuffer.At(0)
-				b := p.rgbBuffer.At(1)
-				c := p.rgbBuffer.At(2)
-				if a[0] == a[1] && b[0] == b[1] && c[0] == c[1] {
-					p.q(a[0:1])
-					p.q(b[0:1])
-					p.q(c[0:1])
-				} else {
-					p.q(p.rgbBuffer.Join(""))
-				}
-			} else {
-				p.q(p.rgbBuffer.Join(""))
-			}
-			p.rgb = false
+			p.colorFunction(append(p.color, tv))
+		case lexer.Function, lexer.LeftParen, lexer.Semicolon, lexer.LeftBrace, lexer.RightBrace:
+			// not a color, calc() and var() can't be computed here
+			p.replay(append(p.color, tv))
+		default:
+			p.color = append(p.color, tv)
 		}
 		return
 	}
+	if token == lexer.Function && !p.replaying && (p.colors() && color.IsFunction(value) || p.Yui && strings.ToLower(value) == "rgb(") {
+		p.color = []lexer.TokenValue{tv}
+		return
+	}
+
+	switch token {
+	case lexer.Function:
+		p.functions = append(p.functions, strings.ToLower(value))
+	case lexer.LeftParen:
+		p.functions = append(p.functions, value)
+	case lexer.RightParen:
+		if len(p.functions) > 0 { p.functions = p.functions[:len(p.functions)-1] }
+	case lexer.Semicolon, lexer.LeftBrace, lexer.RightBrace:
+		p.functions = p.functions[:0]
+	}
 
 	if token == lexer.Whitespace {
 		p.space = true
@@ -208,7 +349,7 @@ func (p *Parser) token(token lexer.Token, value string) {
 		// comments are only needed in a few places:
 		switch {
 		// 1) special comments /*! ... */
-		case len(value) >= 3 && value[2:3] == "!":
+		case len(value) >= 3 && value[2:3] == "!" && !p.NoComments:
 			p.q(value)
 			p.lastToken = token
 			p.lastValue = value
@@ -232,22 +373,31 @@ func (p *Parser) token(token lexer.Token, value string) {
 		return
 	}
 
+	// CDO and CDC are only allowed for hiding the stylesheet from ancient browsers
+	if token == lexer.CDO || token == lexer.CDC {
+		return
+	}
+
 	// most whitespace isn't needed, but make sure we have space between values
 	// for multivalue properties
 	// margin: 5px 5px;
-	isNum  := token == lexer.Number
+	isNum  := isNumeric(token)
 	isHash := token == lexer.Hash
-	isId   := token == lexer.Identifier
-	wasNum := p.lastToken == lexer.Number
-	wasId  := p.lastToken == lexer.Identifier
-	wasPct := p.lastToken == lexer.Percent
+	isId   := token == lexer.Identifier || token == lexer.Function ||
+			token == lexer.URL || token == lexer.UnicodeRange
+	wasNum := isNumeric(p.lastToken)
+	wasId  := p.lastToken == lexer.Identifier || p.lastToken == lexer.Hash ||
+			p.lastToken == lexer.URL || p.lastToken == lexer.UnicodeRange
 	wasRP  := p.lastToken == lexer.RightParen
+	// calc(100% - 10px) needs the space around the operator
+	isSign  := token == lexer.Plus || (token == lexer.Delim && value == "-")
+	wasSign := p.lastToken == lexer.Plus || (p.lastToken == lexer.Delim && p.lastValue == "-")
 
 	aa := isHash || isNum
 	a := aa && wasNum
 
-	ba := isNum || isId || isHash
-	bb := wasId || wasPct || wasRP
+	ba := isNum || isId || isHash || isSign || (wasSign && token == lexer.LeftParen)
+	bb := wasId || wasNum || wasRP || wasSign
 	b := ba && bb && p.space
 
 	if a || b || (token == lexer.String && !isBoundaryOp(p.lastToken)) {
@@ -257,20 +407,16 @@ func (p *Parser) token(token lexer.Token, value string) {
 
 
 	switch {
-	// rgb()
-	case token == lexer.Identifier && value == "rgb":
-		p.rgb = true
-		p.space = false
-		return
-	case p.Yui && token == lexer.Identifier && value == "rgba":
+	case p.Yui && token == lexer.Function && strings.ToLower(value) == "rgba(":
 		p.rgba = true
 		p.q(value)
 	case p.Yui && p.rgba && token == lexer.RightParen:
 		p.rgba = false
 		p.q(value)
-	case token == lexer.At:
+	case token == lexer.AtKeyword:
 		p.q(value)
 		p.at = true
+		p.atRule = tv
 	case p.inRule && token == lexer.Colon && len(p.property) == 0:
 		p.q(value)
 		if len(p.lastValue) != 0 {
@@ -288,9 +434,10 @@ func (p *Parser) token(token lexer.Token, value string) {
 			switch {
 			default:
 				p.dump(value)
-			case p.ruleBuffer.At(1) == "charset":
+			case strings.ToLower(p.atRule.Value) == "@charset":
 				switch {
 				case p.charset:
+					p.warning(p.atRule, "only the first @charset is used")
 					p.ruleBuffer.Reset()
 					p.pending = ZERO_STR
 				default:
@@ -302,7 +449,7 @@ func (p *Parser) token(token lexer.Token, value string) {
 			// skip
 			return
 		default:
-			p.collapseZeroes()
+			p.collapse()
 			p.valueBuffer.Reset()
 			p.property = ZERO_STR
 			p.q(value)
@@ -323,7 +470,7 @@ func (p *Parser) token(token lexer.Token, value string) {
 			p.ruleBuffer.Delete(p.checkSpace)
 			p.checkSpace = -1
 		}
-		if !p.valueBuffer.Empty() { p.collapseZeroes() }
+		if !p.valueBuffer.Empty() { p.collapse() }
 		if p.pending == ";" {
 			p.pending = "}"
 		} else {
@@ -332,7 +479,7 @@ func (p *Parser) token(token lexer.Token, value string) {
 		p.property = ZERO_STR
 		p.inRule = false
 	case !p.inRule:
-		if !p.space || token == lexer.Child || (!p.space && token == lexer.Colon) ||
+		if !p.space || token == lexer.Child || token == lexer.Comma || (!p.space && token == lexer.Colon) ||
 				p.lastToken == lexer.EndToken || isBoundaryOp(p.lastToken) {
 			p.q(value)
 		} else {
@@ -343,7 +490,9 @@ func (p *Parser) token(token lexer.Token, value string) {
 			p.q(value)
 			p.space = false
 		}
-	case token == lexer.Number && len(value) > 2 && value[:2] == "0." && !(p.Yui && p.rgba):
+	case !p.Yui && isNumeric(token):
+		p.q(p.normalize(tv))
+	case isNumeric(token) && len(value) > 2 && value[:2] == "0." && !(p.Yui && p.rgba):
 		p.q(value[1:])
 	case token == lexer.String && p.property == "-ms-filter":
 		if len(value) >= len(MS_ALPHA)+2 && strings.ToLower(value[1:len(MS_ALPHA)+1]) == MS_ALPHA {
@@ -365,31 +514,33 @@ func (p *Parser) token(token lexer.Token, value string) {
 		}
 	default:
 		t := strings.ToLower(value)
+		short := p.shortColor(tv)
 		switch {
-		// values of 0 don't need a unit
-		case p.lastToken == lexer.Number && p.lastValue == "0" &&
-				(token == lexer.Percent || token == lexer.Identifier):
-			if !in(UNITS, value) {
-				p.q(" ")
-				p.q(value)
-			}
+		// values of 0 don't need a unit, except in color functions
+		case token == lexer.Percentage && number(tv) == "0" && !p.replaying:
+			p.q("0")
+		case token == lexer.Dimension && number(tv) == "0" && in(UNITS, tv.Unit):
+			p.q("0")
 		// use 0 instead of none
 		case value == "none" && p.lastToken == lexer.Colon && in(NONE_PROPERTIES, p.property):
 			p.q("0")
+		case short != ZERO_STR:
+			p.q(short)
+		// #aabbcc
+		case token == lexer.Hash:
+			if len(t) == 7 &&
+					t[1] == t[2] &&
+					t[3] == t[4] &&
+					t[5] == t[6] {
+				p.q(t[0:2])
+				p.q(t[3:4])
+				p.q(t[5:6])
+			} else {
+				p.q(t)
+			}
 		// force properties to lower case for better gzip compression
 		case token == lexer.Identifier && p.lastToken != lexer.Colon:
 			switch {
-			// #aabbcc
-			case p.lastToken == lexer.Hash:
-				if len(value) == 6 &&
-						t[0] == t[1] &&
-						t[2] == t[3] &&
-						t[4] == t[5] {
-					p.q(t[1:3])
-					p.q(t[4:5])
-				} else {
-					p.q(t)
-				}
 			case p.property == ZERO_STR || in(KEYWORDS, t):
 				p.q(t)
 			default:
@@ -409,23 +560,38 @@ func (p *Parser) token(token lexer.Token, value string) {
 	p.space = false
 }
 
-func (p *Parser) end() {
+func (p *Parser) end(tv lexer.TokenValue) {
+	if p.color != nil { p.replay(p.color) }
+	if p.depth > 0 {
+		p.warning(tv, "unexpected end of file, %d block(s) not closed", p.depth)
+	}
 	p.write(p.pending)
 	if !p.ruleBuffer.Empty() {
-		p.Out <- p.ruleBuffer.Join("")
+		p.emit(p.ruleBuffer.Join(""))
+	}
+	if p.W != nil {
+		if err := p.W.Flush(); err != nil && p.Err == nil { p.Err = err }
 	}
 }
 
-func (p *Parser) Run() {
-	var tv lexer.TokenValue
+// Compresses the tokens of in up to the end token, returns the first error
+// writing the output
+func (p *Parser) Parse(in lexer.TokenReader) os.Error {
 	for {
-		tv = <- p.In
+		tv := in.Next()
 		if tv.Token == lexer.EndToken {
-			p.end()
-			p.Out <- ZERO_STR
-			return
-		} else {
-			p.token(tv.Token, tv.Value)
+			p.end(tv)
+			return p.Err
 		}
+		p.check(tv)
+		p.token(tv)
 	}
+	panic("unreachable")
+}
+
+// Compresses the tokens read from In, for running in a goroutine of its own
+func (p *Parser) Run() {
+	p.Parse(lexer.Channel(p.In))
+	if p.W == nil { p.Out <- ZERO_STR }
+	if p.Eof != nil { p.Eof <- 0 }
 }
@@ -0,0 +1,99 @@
+package parser
+
+import (
+	"./lexer"
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func compress(css string, yui bool) string {
+	var b bytes.Buffer
+	CreateWriter(&b, yui).Parse(lexer.CreateReader(strings.NewReader(css)))
+	return b.String()
+}
+
+type compressTest struct {
+	in       string
+	out, yui string
+}
+
+func testCompress(t *testing.T, tests []compressTest) {
+	for _, test := range tests {
+		if out := compress(test.in, false); out != test.out { t.Errorf("%q: %q, want %q", test.in, out, test.out) }
+		if out := compress(test.in, true); out != test.yui { t.Errorf("%q with -y: %q, want %q", test.in, out, test.yui) }
+	}
+}
+
+// whitespace before a comma never separates two selectors, YUI Compressor
+// drops it as well
+var selectorTests = []compressTest{
+	{"a , b{c:d}", "a,b{c:d}", "a,b{c:d}"},
+	{"a\n,\nb > c ,d{e:f}", "a,b>c,d{e:f}", "a,b>c,d{e:f}"},
+	{"a ,b{c:d , e}", "a,b{c:d,e}", "a,b{c:d,e}"},
+	{"@media screen , print{a , b{c:d}}", "@media screen,print{a,b{c:d}}", "@media screen,print{a,b{c:d}}"},
+}
+
+func TestSelectors(t *testing.T) {
+	testCompress(t, selectorTests)
+}
+
+// colors are written in their shortest form, YUI Compressor only writes
+// rgb() in hex and shortens #aabbcc
+var colorTests = []compressTest{
+	{"a{color:#ff0000}", "a{color:red}", "a{color:#f00}"},
+	{"a{color:white;background:WHITE url(white.png)}", "a{color:#fff;background:#fff url(white.png)}", "a{color:white;background:WHITE url(white.png)}"},
+	{"a{color:rgb(0,0,255)}", "a{color:#00f}", "a{color:#00f}"},
+	{"a{border:1px solid rgb(255 255 255)}", "a{border:1px solid #fff}", "a{border:1px solid #fff}"},
+	{"a{color:rgb(1, 2, 3)}", "a{color:#010203}", "a{color:#010203}"},
+	{"a{color:rgba(0,0,0,1)}", "a{color:#000}", "a{color:rgba(0,0,0,1)}"},
+	{"a{color:rgb(10%,0%,0%)}", "a{color:rgb(10%,0%,0%)}", "a{color:rgb(10%,0%,0%)}"},
+	{"a{color:rgb(var(--r),0,0)}", "a{color:rgb(var(--r),0,0)}", "a{color:rgb(var(--r),0,0)}"},
+	{"a{color:#aabbccdd}", "a{color:#abcd}", "a{color:#aabbccdd}"},
+	{"a{box-shadow:0 0 1px rgba(0,0,0,0)}", "a{box-shadow:0 0 1px transparent}", "a{box-shadow:0 0 1px rgba(0,0,0,0)}"},
+	{"a{background:linear-gradient(rgb(255,0,0),hsl(120,100%,25%))}", "a{background:linear-gradient(red,hsl(120,100%,25%))}", "a{background:linear-gradient(#f00,hsl(120,100%,25%))}"},
+	{"a{color:hsl(0 100% 50% / .2)}", "a{color:hsl(0 100% 50%/.2)}", "a{color:hsl(0 100% 50%/.2)}"},
+	{"a{color:rgb(255,0,0)b}", "a{color:red b}", "a{color:#f00 b}"},
+	// not colors, or not safe to change
+	{"a{color:lab(50% 40 59)}", "a{color:lab(50% 40 59)}", "a{color:lab(50% 40 59)}"},
+	{"a{color:currentColor}", "a{color:currentColor}", "a{color:currentColor}"},
+	{"a{font-family:Red}", "a{font-family:Red}", "a{font-family:Red}"},
+	{"a{--c:#ff0000}", "a{--c:#f00}", "a{--c:#f00}"},
+	{"a{filter:progid:DXImageTransform.Microsoft.gradient(startColorstr=#ff000000)}",
+		"a{filter:progid:DXImageTransform.Microsoft.gradient(startColorstr=#ff000000)}",
+		"a{filter:progid:DXImageTransform.Microsoft.gradient(startColorstr=#ff000000)}"},
+	{"#ff0000{color:red}", "#ff0000{color:red}", "#ff0000{color:red}"},
+}
+
+func TestColors(t *testing.T) {
+	testCompress(t, colorTests)
+}
+// tokens lexed beforehand, so only the parser is measured
+type tokens struct {
+	values []lexer.TokenValue
+	i      int
+}
+
+func (t *tokens) Next() (tv lexer.TokenValue) {
+	if t.i == len(t.values) { return }
+	tv = t.values[t.i]
+	t.i++
+	return
+}
+
+func BenchmarkParser(b *testing.B) {
+	b.StopTimer()
+	css, err := ioutil.ReadFile("../testdata/rustdoc.css")
+	if err != nil { b.Fatal(err) }
+	var values []lexer.TokenValue
+	lex := lexer.CreateReader(bytes.NewBuffer(css))
+	for tv := lex.Next(); tv.Token != lexer.EndToken; tv = lex.Next() {
+		values = append(values, tv)
+	}
+	b.SetBytes(int64(len(css)))
+	b.StartTimer()
+	for i := 0; i < b.N; i++ {
+		CreateWriter(ioutil.Discard, false).Parse(&tokens{values: values})
+	}
+}
@@ -0,0 +1,121 @@
+// Shortest forms of shorthand values
+package parser
+
+import (
+	"strings"
+)
+
+// How the values of a property can be shortened
+const (
+	// top, right, bottom and left, a missing value is copied from the
+	// opposite side
+	BOX = iota + 1
+	// corners like BOX, optionally followed by a / and the vertical radii
+	RADIUS
+	// two values, the second is copied from the first when it is missing
+	PAIR
+)
+
+// Properties not in the table keep their values. Prefixed radii are left
+// alone, old WebKit reads two values as one elliptical corner.
+var SHORTHANDS = map[string] int {
+	"margin":                     BOX,
+	"padding":                    BOX,
+	"border-width":               BOX,
+	"border-style":               BOX,
+	"border-color":               BOX,
+	"inset":                      BOX,
+	"scroll-margin":              BOX,
+	"scroll-padding":             BOX,
+	"border-radius":              RADIUS,
+	"border-top-left-radius":     PAIR,
+	"border-top-right-radius":    PAIR,
+	"border-bottom-right-radius": PAIR,
+	"border-bottom-left-radius":  PAIR,
+	"border-spacing":             PAIR,
+	"gap":                        PAIR,
+	"grid-gap":                   PAIR,
+}
+
+// Splits s at sep outside of parentheses and strings
+func splitTop(s string, sep byte) (parts []string) {
+	depth := 0
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// Drops values a box property would copy from the opposite side
+func box(values []string) []string {
+	n := len(values)
+	if n == 4 && values[3] == values[1] { n = 3 }
+	if n == 3 && values[2] == values[0] { n = 2 }
+	if n == 2 && values[1] == values[0] { n = 1 }
+	return values[:n]
+}
+
+// Shortest form of the value of property, values that may expand to more
+// than one, comments and hacks are kept as they are
+func shorthand(property, value string) string {
+	kind, ok := SHORTHANDS[property]
+	lower := strings.ToLower(value)
+	if !ok || strings.Index(lower, "var(") >= 0 || strings.Index(value, "/*") >= 0 || strings.Index(value, "\\") >= 0 {
+		return value
+	}
+
+	// !important stays at the end
+	suffix := ""
+	if i := strings.Index(value, "!"); i >= 0 {
+		value, suffix = value[:i], value[i:]
+	}
+
+	sides := splitTop(value, '/')
+	if len(sides) > 2 || (len(sides) == 2 && kind != RADIUS) { return value + suffix }
+
+	collapsed := make([]string, len(sides))
+	for i, side := range sides {
+		values := splitTop(side, ' ')
+		if len(values) > 4 || (kind == PAIR && len(values) > 2) { return value + suffix }
+		for _, v := range values {
+			// doubled spaces come from hacks, they are kept
+			if v == "" { return value + suffix }
+		}
+		collapsed[i] = strings.Join(box(values), " ")
+	}
+	// vertical radii the same as the horizontal ones
+	if len(collapsed) == 2 && collapsed[1] == collapsed[0] { collapsed = collapsed[:1] }
+	return strings.Join(collapsed, "/") + suffix
+}
+
+// As YUI Compressor: values of only zeroes are 0, except for the background
+// and transform origin positions
+func yuiZeroes(property, value string) string {
+	switch {
+	case value == "0 0" || value == "0 0 0" || value == "0 0 0 0":
+		if property == "background-position" || property == "-webkit-transform-origin" || property == "-moz-transform-origin" {
+			return "0 0"
+		}
+		return "0"
+	}
+	return value
+}
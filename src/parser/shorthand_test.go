@@ -0,0 +1,57 @@
+package parser
+
+import (
+	"testing"
+)
+
+var shorthandTests = []struct{ property, in, out string }{
+	{"margin", "1px 2px 1px 2px", "1px 2px"},
+	{"margin", "1px 2px 3px 2px", "1px 2px 3px"},
+	{"margin", "1px 2px 1px 3px", "1px 2px 1px 3px"},
+	{"padding", "0 0 0 0", "0"},
+	{"inset", "auto auto", "auto"},
+	{"border-color", "red blue red", "red blue"},
+	{"border-radius", "1px 1px/2px 2px", "1px/2px"},
+	{"border-radius", "1px 2px/1px 2px", "1px 2px"},
+	{"border-radius", "1px/2px/3px", "1px/2px/3px"},
+	{"border-top-left-radius", "1px 1px", "1px"},
+	{"border-top-left-radius", "1px 2px 3px", "1px 2px 3px"},
+	{"gap", "1em 1em", "1em"},
+	{"margin", "1px 1px 1px 1px 1px", "1px 1px 1px 1px 1px"},
+	{"margin", "1px 1px!important", "1px!important"},
+	{"margin", "calc(1px + 2px) calc(1px + 2px)", "calc(1px + 2px)"},
+	{"margin", "calc(1px + 2px) calc(1px  + 2px)", "calc(1px + 2px) calc(1px  + 2px)"},
+	{"margin", "1px/**/1px", "1px/**/1px"},
+	{"margin", "1px  1px", "1px  1px"},
+	{"margin", "var(--a) var(--a)", "var(--a) var(--a)"},
+	{"margin", "\\31 px \\31 px", "\\31 px \\31 px"},
+	// the number of values changes what they mean
+	{"flex", "0 0 0", "0 0 0"},
+	{"background-size", "0 0", "0 0"},
+	{"background-position", "0 0", "0 0"},
+	{"box-shadow", "0 0 0 0", "0 0 0 0"},
+	{"transform-origin", "0 0 0", "0 0 0"},
+	{"-webkit-border-radius", "1px 1px", "1px 1px"},
+}
+
+func TestShorthand(t *testing.T) {
+	for _, test := range shorthandTests {
+		if out := shorthand(test.property, test.in); out != test.out { t.Errorf("%s: %q, want %q", test.property, out, test.out) }
+	}
+}
+
+// YUI Compressor collapses any value of only zeroes
+var shorthandCompressTests = []compressTest{
+	{"a{margin:1px 2px 1px 2px}", "a{margin:1px 2px}", "a{margin:1px 2px 1px 2px}"},
+	{"a{MARGIN:0 0}", "a{margin:0}", "a{margin:0}"},
+	{"a{margin:0px 0em 0 0}", "a{margin:0}", "a{margin:0}"},
+	{"a{border-radius:1px/1px}", "a{border-radius:1px}", "a{border-radius:1px/1px}"},
+	{"a{flex:0 0 0}", "a{flex:0 0 0}", "a{flex:0}"},
+	{"a{background-size:0 0}", "a{background-size:0 0}", "a{background-size:0}"},
+	{"a{background-position:0 0}", "a{background-position:0 0}", "a{background-position:0 0}"},
+	{"a{-moz-transform-origin:0 0 0}", "a{-moz-transform-origin:0 0 0}", "a{-moz-transform-origin:0 0}"},
+}
+
+func TestShorthands(t *testing.T) {
+	testCompress(t, shorthandCompressTests)
+}
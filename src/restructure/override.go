@@ -0,0 +1,260 @@
+// Declarations overridden later in the same block
+package restructure
+
+import (
+	"./ast"
+	"./color"
+	"./lexer"
+	"strings"
+)
+
+var (
+	// longhands a shorthand sets, vendor prefixes are ignored
+	LONGHANDS = map[string] []string {
+		"margin":          {"margin-top", "margin-right", "margin-bottom", "margin-left"},
+		"padding":         {"padding-top", "padding-right", "padding-bottom", "padding-left"},
+		"inset":           {"top", "right", "bottom", "left"},
+		"border":          {"border-top", "border-right", "border-bottom", "border-left",
+			"border-width", "border-style", "border-color",
+			"border-top-width", "border-right-width", "border-bottom-width", "border-left-width",
+			"border-top-style", "border-right-style", "border-bottom-style", "border-left-style",
+			"border-top-color", "border-right-color", "border-bottom-color", "border-left-color"},
+		"border-top":      {"border-top-width", "border-top-style", "border-top-color"},
+		"border-right":    {"border-right-width", "border-right-style", "border-right-color"},
+		"border-bottom":   {"border-bottom-width", "border-bottom-style", "border-bottom-color"},
+		"border-left":     {"border-left-width", "border-left-style", "border-left-color"},
+		"border-width":    {"border-top-width", "border-right-width", "border-bottom-width", "border-left-width"},
+		"border-style":    {"border-top-style", "border-right-style", "border-bottom-style", "border-left-style"},
+		"border-color":    {"border-top-color", "border-right-color", "border-bottom-color", "border-left-color"},
+		"border-radius":   {"border-top-left-radius", "border-top-right-radius",
+			"border-bottom-right-radius", "border-bottom-left-radius"},
+		"outline":         {"outline-width", "outline-style", "outline-color"},
+		"background":      {"background-color", "background-image", "background-repeat", "background-attachment",
+			"background-position", "background-position-x", "background-position-y",
+			"background-size", "background-origin", "background-clip"},
+		"font":            {"font-style", "font-variant", "font-weight", "font-stretch", "font-size",
+			"line-height", "font-family"},
+		"list-style":      {"list-style-type", "list-style-position", "list-style-image"},
+		"overflow":        {"overflow-x", "overflow-y"},
+		"flex":            {"flex-grow", "flex-shrink", "flex-basis"},
+		"flex-flow":       {"flex-direction", "flex-wrap"},
+		"gap":             {"row-gap", "column-gap"},
+		"columns":         {"column-width", "column-count"},
+		"text-decoration": {"text-decoration-line", "text-decoration-style", "text-decoration-color"},
+		"transition":      {"transition-property", "transition-duration", "transition-timing-function",
+			"transition-delay"},
+		"animation":       {"animation-name", "animation-duration", "animation-timing-function",
+			"animation-delay", "animation-iteration-count", "animation-direction",
+			"animation-fill-mode", "animation-play-state"},
+	}
+	// lengths of CSS 2, every browser knows them
+	CSS2_LENGTHS = map[string] bool {
+		"px": true, "em": true, "ex": true, "in": true, "cm": true, "mm": true, "pt": true, "pc": true,
+	}
+	// Values properties have had since CSS 2, a declaration only overrides
+	// another when its value is one of these. Besides keywords there are
+	// <length> in CSS 2 units or 0, <percentage>, <number>, <integer> and
+	// <color>, a hex color or a name.
+	CSS2_VALUES = map[string] *css2 {
+		"margin":                {"<length> <percentage> auto", 4},
+		"padding":               {"<length> <percentage>", 4},
+		"border-width":          {"<length> thin medium thick", 4},
+		"border-style":          {"none hidden dotted dashed solid double groove ridge inset outset", 4},
+		"border-color":          {"<color> transparent", 4},
+		"width":                 {"<length> <percentage> auto", 1},
+		"height":                {"<length> <percentage> auto", 1},
+		"min-width":             {"<length> <percentage>", 1},
+		"min-height":            {"<length> <percentage>", 1},
+		"max-width":             {"<length> <percentage> none", 1},
+		"max-height":            {"<length> <percentage> none", 1},
+		"color":                 {"<color>", 1},
+		"background-color":      {"<color> transparent", 1},
+		"background-repeat":     {"repeat repeat-x repeat-y no-repeat", 1},
+		"background-attachment": {"scroll fixed", 1},
+		"outline-width":         {"<length> thin medium thick", 1},
+		"outline-style":         {"none dotted dashed solid double groove ridge inset outset", 1},
+		"outline-color":         {"<color> invert", 1},
+		"font-size":             {"<length> <percentage> xx-small x-small small medium large x-large xx-large smaller larger", 1},
+		"font-style":            {"normal italic oblique", 1},
+		"font-variant":          {"normal small-caps", 1},
+		"font-weight":           {"normal bold bolder lighter 100 200 300 400 500 600 700 800 900", 1},
+		"line-height":           {"<length> <percentage> <number> normal", 1},
+		"text-align":            {"left right center justify", 1},
+		"text-decoration":       {"none underline overline line-through blink", 1},
+		"text-indent":           {"<length> <percentage>", 1},
+		"text-transform":        {"none capitalize uppercase lowercase", 1},
+		"letter-spacing":        {"<length> normal", 1},
+		"word-spacing":          {"<length> normal", 1},
+		"white-space":           {"normal pre nowrap pre-wrap pre-line", 1},
+		"vertical-align":        {"<length> <percentage> baseline sub super top text-top middle bottom text-bottom", 1},
+		"display":               {"none inline block inline-block list-item table inline-table table-row-group " +
+			"table-header-group table-footer-group table-row table-column-group table-column table-cell table-caption", 1},
+		"position":              {"static relative absolute fixed", 1},
+		"top":                   {"<length> <percentage> auto", 1},
+		"right":                 {"<length> <percentage> auto", 1},
+		"bottom":                {"<length> <percentage> auto", 1},
+		"left":                  {"<length> <percentage> auto", 1},
+		"float":                 {"none left right", 1},
+		"clear":                 {"none left right both", 1},
+		"visibility":            {"visible hidden collapse", 1},
+		"overflow":              {"visible hidden scroll auto", 1},
+		"z-index":               {"<integer> auto", 1},
+		"cursor":                {"auto crosshair default pointer move e-resize ne-resize nw-resize n-resize " +
+			"se-resize sw-resize s-resize w-resize text wait help progress", 1},
+		"list-style-type":       {"disc circle square decimal decimal-leading-zero lower-roman upper-roman " +
+			"lower-greek lower-latin upper-latin lower-alpha upper-alpha armenian georgian none", 1},
+		"list-style-position":   {"inside outside", 1},
+		"table-layout":          {"auto fixed", 1},
+		"border-collapse":       {"collapse separate", 1},
+		"direction":             {"ltr rtl", 1},
+		"unicode-bidi":          {"normal embed bidi-override", 1},
+	}
+)
+
+// Values of a property and how many of them a declaration may have
+type css2 struct {
+	values string
+	terms  int
+}
+
+// the sides of the box properties take the values of one side
+func init() {
+	for _, box := range []string{"margin", "padding", "border"} {
+		for _, side := range []string{"top", "right", "bottom", "left"} {
+			if box != "border" {
+				CSS2_VALUES[box + "-" + side] = &css2{CSS2_VALUES[box].values, 1}
+				continue
+			}
+			for _, part := range []string{"width", "style", "color"} {
+				CSS2_VALUES[box + "-" + side + "-" + part] = &css2{CSS2_VALUES[box + "-" + part].values, 1}
+			}
+		}
+	}
+}
+
+// Property a declaration sets, custom properties are case sensitive
+func name(d *ast.Declaration) string {
+	if strings.HasPrefix(d.Property, "--") { return d.Property }
+	return strings.ToLower(d.Property)
+}
+
+// True when shorthand is longhand or sets it, both with the same vendor prefix
+func covers(shorthand, longhand string) bool {
+	if shorthand == longhand { return true }
+	s, l := unprefixed(shorthand), unprefixed(longhand)
+	if shorthand[:len(shorthand) - len(s)] != longhand[:len(longhand) - len(l)] { return false }
+	for _, p := range LONGHANDS[s] {
+		if p == l { return true }
+	}
+	return false
+}
+
+// True when tv is one of the values
+func css2Value(values []string, tv lexer.TokenValue) bool {
+	value := strings.ToLower(tv.Value)
+	for _, v := range values {
+		switch v {
+		case value:
+			return tv.Token == lexer.Identifier || tv.Token == lexer.Number
+		case "<length>":
+			if tv.Token == lexer.Dimension && CSS2_LENGTHS[strings.ToLower(tv.Unit)] { return true }
+			if tv.Token == lexer.Number && value == "0" { return true }
+		case "<percentage>":
+			if tv.Token == lexer.Percentage { return true }
+		case "<number>":
+			if tv.Token == lexer.Number { return true }
+		case "<integer>":
+			if tv.Token == lexer.Number && strings.IndexAny(value, ".e") < 0 { return true }
+		case "<color>":
+			switch tv.Token {
+			case lexer.Hash:
+				if len(value) != 4 && len(value) != 7 { continue }
+			case lexer.Identifier:
+				if value == "transparent" || value == "currentcolor" { continue }
+			default:
+				continue
+			}
+			if _, ok := color.Parse(value); ok { return true }
+		}
+	}
+	return false
+}
+
+// True when every browser understands the value of the declaration: values
+// of CSS 2 for the property, inherit on its own
+func known(d *ast.Declaration) bool {
+	p := CSS2_VALUES[name(d)]
+	if p == nil { return false }
+	var terms []lexer.TokenValue
+	for _, cv := range d.Value {
+		if cv.Token.Token == lexer.Whitespace { continue }
+		if len(cv.Children) > 0 { return false }
+		terms = append(terms, cv.Token)
+	}
+	if len(terms) == 1 && terms[0].Token == lexer.Identifier && strings.ToLower(terms[0].Value) == "inherit" { return true }
+	if len(terms) == 0 || len(terms) > p.terms { return false }
+	values := strings.Fields(p.values)
+	for _, tv := range terms {
+		if !css2Value(values, tv) { return false }
+	}
+	return true
+}
+
+// True when winner, wherever it's understood, takes the place of loser. Later
+// declarations win unless only the earlier one is important. Fallbacks stay:
+// the winner has to be understood by every browser, or the same as the loser.
+func overrides(winner, loser *ast.Declaration, later bool) bool {
+	if !covers(name(winner), name(loser)) { return false }
+	if later && loser.Important && !winner.Important { return false }
+	if !later && !(winner.Important && !loser.Important) { return false }
+	if known(winner) { return true }
+	return winner.Property == loser.Property && ast.Serialize(winner.Value) == ast.Serialize(loser.Value)
+}
+
+// Drops declarations another one in the block overrides, true when there
+// were any
+func overrideDeclarations(declarations []ast.Node) (kept []ast.Node, changed bool) {
+	for i, node := range declarations {
+		d, ok := node.(*ast.Declaration)
+		dropped := false
+		for j, other := range declarations {
+			o, isDeclaration := other.(*ast.Declaration)
+			if ok && isDeclaration && i != j && overrides(o, d, j > i) {
+				dropped = true
+				break
+			}
+		}
+		if dropped {
+			changed = true
+		} else {
+			kept = append(kept, node)
+		}
+	}
+	return
+}
+
+// Drops overridden declarations in every block written by the model
+func (s *source) override(rules []ast.Node) (changed bool) {
+	for _, node := range rules {
+		if s.verbatim[node] != nil { continue }
+		var block *ast.Block
+		switch n := node.(type) {
+		case *ast.QualifiedRule:
+			block = n.Block
+		case *ast.AtRule:
+			block = n.Block
+		}
+		if block == nil { continue }
+
+		var c bool
+		switch block.Kind {
+		case ast.RuleBlock:
+			c = s.override(block.Rules)
+		case ast.DeclarationBlock:
+			block.Declarations, c = overrideDeclarations(block.Declarations)
+			c = s.override(block.Declarations) || c
+		}
+		changed = changed || c
+	}
+	return
+}
@@ -0,0 +1,327 @@
+// Restructuring of compressed stylesheets
+package restructure
+
+import (
+	"./ast"
+	"./lexer"
+	"bytes"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Groups of properties setting the same values, by their name or the first
+// word of it. Words not in the table are a group of their own.
+var GROUPS = map[string] string {
+	"top": "inset", "right": "inset", "bottom": "inset", "left": "inset", "inset": "inset",
+	"width": "size", "height": "size", "inline": "size", "block": "size", "min": "size", "max": "size",
+	"line": "font",
+	"row": "gap", "gap": "gap", "column-gap": "gap", "grid-gap": "gap", "grid-row-gap": "gap", "grid-column-gap": "gap",
+	"columns": "column",
+	"place": "align", "justify": "align",
+	// aliases, old names of the same properties
+	"word-wrap": "overflow", "overflow-wrap": "overflow",
+	"page-break-before": "break", "page-break-after": "break", "page-break-inside": "break",
+	"white": "text",
+}
+
+// Pseudo-classes and elements every browser knows, a rule with any other is
+// only merged with rules of the same selectors. Browsers drop a whole rule
+// for a single selector they don't understand.
+var SAFE_PSEUDOS = map[string] bool {
+	"link": true, "visited": true, "hover": true, "active": true, "focus": true,
+	"first-child": true, "last-child": true, "only-child": true,
+	"first-of-type": true, "last-of-type": true, "only-of-type": true,
+	"nth-child(": true, "nth-last-child(": true, "nth-of-type(": true, "nth-last-of-type(": true,
+	"empty": true, "root": true, "target": true, "checked": true, "disabled": true, "enabled": true,
+	"lang(": true, "before": true, "after": true, "first-letter": true, "first-line": true,
+}
+
+// Buffers a compressed stylesheet, Close restructures it and writes it to W
+type Writer struct {
+	bytes.Buffer
+	W        io.Writer
+	// rules with the same selectors or declarations are merged
+	Merge    bool
+	// declarations overridden in the same block are dropped
+	Override bool
+}
+
+func CreateWriter(w io.Writer, merge, override bool) (rw *Writer) {
+	rw = &Writer{W: w, Merge: merge, Override: override}
+	return
+}
+
+func (w *Writer) Close() (err os.Error) {
+	_, err = w.W.Write(Restructure(w.Bytes(), w.Merge, w.Override))
+	return
+}
+
+// Rules of a stylesheet and their source text
+type source struct {
+	css      []byte
+	// text of rules the model doesn't give back as they were written, like
+	// those with hacks, they are written as they were and never merged
+	verbatim map[ast.Node] []byte
+}
+
+// Merges the rules of the compressed stylesheet css, with merge, and drops
+// overridden declarations, with override
+func Restructure(css []byte, merge, override bool) []byte {
+	lex := lexer.CreateReader(bytes.NewBuffer(css))
+	go lex.Run()
+	sheet := ast.CreateBuilder(lex.Out).Build()
+	if len(sheet.Rules) == 0 { return css }
+
+	s := &source{css: css, verbatim: make(map[ast.Node] []byte)}
+	s.check(sheet.Rules, len(css))
+	rules, changed := sheet.Rules, false
+	if merge { rules, changed = s.merge(rules) }
+	// after merging, so declarations of merged rules are dropped as well
+	if override && s.override(rules) { changed = true }
+	if !changed { return css }
+
+	var b bytes.Buffer
+	b.Write(css[:sheet.Rules[0].Pos().Offset])
+	s.write(&b, rules)
+	return b.Bytes()
+}
+
+// Compares the rules with the text they were built from, which ends at end
+func (s *source) check(rules []ast.Node, end int) {
+	for i, node := range rules {
+		stop := end
+		if i + 1 < len(rules) { stop = rules[i+1].Pos().Offset }
+		text := s.css[node.Pos().Offset:stop]
+		if s.matches(node, text) { continue }
+
+		// rules in a block may still match on their own
+		if r, ok := node.(*ast.AtRule); ok && r.Block != nil && r.Block.Kind == ast.RuleBlock &&
+				len(r.Block.Rules) > 0 && bytes.HasSuffix(text, []byte("}")) {
+			s.check(r.Block.Rules, stop - 1)
+			if s.matches(node, text) { continue }
+		}
+		s.verbatim[node] = text
+	}
+}
+
+func (s *source) matches(node ast.Node, text []byte) bool {
+	var b bytes.Buffer
+	s.write(&b, []ast.Node{node})
+	return bytes.Equal(b.Bytes(), text)
+}
+
+// Merges rules into an earlier one with the same selectors or the same
+// declarations, when no rule between them sets any of their properties
+func (s *source) merge(rules []ast.Node) (merged []ast.Node, changed bool) {
+	for _, node := range rules {
+		if r, ok := node.(*ast.AtRule); ok && r.Block != nil && r.Block.Kind == ast.RuleBlock &&
+				ast.Unprefixed(r.Name) != "keyframes" && s.verbatim[r] == nil {
+			var c bool
+			r.Block.Rules, c = s.merge(r.Block.Rules)
+			changed = changed || c
+		}
+		r, ok := node.(*ast.QualifiedRule)
+		if !ok || !s.mergeable(r) {
+			merged = append(merged, node)
+			continue
+		}
+		if unique(r) { changed = true }
+
+		i := s.target(merged, r)
+		if i < 0 {
+			merged = append(merged, node)
+			continue
+		}
+		changed = true
+		t := merged[i].(*ast.QualifiedRule)
+		switch {
+		case selectorKey(t) == selectorKey(r) && s.body(t) == s.body(r):
+			// the same rule twice
+		case selectorKey(t) == selectorKey(r):
+			t.Block.Declarations = append(t.Block.Declarations, r.Block.Declarations...)
+		default:
+			t.Selectors = append(t.Selectors, r.Selectors...)
+			unique(t)
+		}
+	}
+	return
+}
+
+// index of the rule r can be merged into, -1 when there's none
+func (s *source) target(rules []ast.Node, r *ast.QualifiedRule) int {
+	for i := len(rules) - 1; i >= 0; i-- {
+		t, ok := rules[i].(*ast.QualifiedRule)
+		if !ok || !s.mergeable(t) { return -1 }
+		switch {
+		case selectorKey(t) == selectorKey(r):
+			return i
+		case s.body(t) == s.body(r) && safe(t) && safe(r):
+			return i
+		case overlaps(t, r):
+			return -1
+		}
+	}
+	return -1
+}
+
+// Only rules written by the model, holding nothing but declarations and
+// without comments in their selectors, are merged
+func (s *source) mergeable(r *ast.QualifiedRule) bool {
+	if s.verbatim[r] != nil { return false }
+	for _, d := range r.Block.Declarations {
+		if _, ok := d.(*ast.Declaration); !ok { return false }
+	}
+	for _, sel := range r.Selectors {
+		if strings.Contains(sel.String(), "/*") { return false }
+	}
+	return len(r.Block.Declarations) > 0
+}
+
+// Drops selectors listed before, true when there were any
+func unique(r *ast.QualifiedRule) bool {
+	seen := make(map[string] bool)
+	selectors := r.Selectors[:0]
+	for _, s := range r.Selectors {
+		if !seen[s.String()] { selectors = append(selectors, s) }
+		seen[s.String()] = true
+	}
+	dropped := len(selectors) < len(r.Selectors)
+	r.Selectors = selectors
+	return dropped
+}
+
+// the selectors in any order
+func selectorKey(r *ast.QualifiedRule) string {
+	keys := make([]string, len(r.Selectors))
+	for i, s := range r.Selectors {
+		keys[i] = s.String()
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+func (s *source) body(r *ast.QualifiedRule) string {
+	var b bytes.Buffer
+	s.writeDeclarations(&b, r.Block.Declarations)
+	return b.String()
+}
+
+// True when every pseudo-class and element of the selectors is in SAFE_PSEUDOS
+func safe(r *ast.QualifiedRule) bool {
+	for _, s := range r.Selectors {
+		if !safeValues(s.Values) { return false }
+	}
+	return true
+}
+
+func safeValues(values []*ast.ComponentValue) bool {
+	for i, cv := range values {
+		if !cv.Is(lexer.Colon) { continue }
+		j := i + 1
+		if j < len(values) && values[j].Is(lexer.Colon) { j++ }
+		if j == len(values) { return false }
+		name := strings.ToLower(values[j].Token.Value)
+		if !SAFE_PSEUDOS[name] || !safeValues(values[j].Children) { return false }
+	}
+	return true
+}
+
+// group of a property, all is in every group
+func group(property string) string {
+	// the prefixed property may be an alias
+	property = unprefixed(strings.ToLower(property))
+	if g, ok := GROUPS[property]; ok { return g }
+	if i := strings.Index(property, "-"); i > 0 { property = property[:i] }
+	if g, ok := GROUPS[property]; ok { return g }
+	return property
+}
+
+// strips a vendor prefix
+func unprefixed(property string) string {
+	if len(property) > 1 && property[0] == '-' && property[1] != '-' {
+		if i := strings.Index(property[1:], "-"); i >= 0 { return property[i+2:] }
+	}
+	return property
+}
+
+// True when the rules set properties of the same group
+func overlaps(a, b *ast.QualifiedRule) bool {
+	groups := make(map[string] bool)
+	for _, d := range a.Block.Declarations {
+		groups[group(d.(*ast.Declaration).Property)] = true
+	}
+	for _, d := range b.Block.Declarations {
+		g := group(d.(*ast.Declaration).Property)
+		if groups[g] || groups["all"] || g == "all" { return true }
+	}
+	return false
+}
+
+func (s *source) write(b *bytes.Buffer, rules []ast.Node) {
+	for _, node := range rules {
+		if text := s.verbatim[node]; text != nil {
+			b.Write(text)
+			continue
+		}
+		switch n := node.(type) {
+		case *ast.Comment:
+			b.WriteString(n.Text)
+		case *ast.AtRule:
+			s.writeAtRule(b, n)
+		case *ast.QualifiedRule:
+			for i, sel := range n.Selectors {
+				if i > 0 { b.WriteString(",") }
+				b.WriteString(sel.String())
+			}
+			b.WriteString("{")
+			s.writeDeclarations(b, n.Block.Declarations)
+			b.WriteString("}")
+		}
+	}
+}
+
+func (s *source) writeAtRule(b *bytes.Buffer, r *ast.AtRule) {
+	b.WriteString("@" + r.Name)
+	if len(r.Prelude) > 0 {
+		// the space is only needed before names and numbers
+		switch r.Prelude[0].Token.Token {
+		case lexer.String, lexer.LeftParen:
+		default:
+			b.WriteString(" ")
+		}
+		b.WriteString(ast.Serialize(r.Prelude))
+	}
+	if r.Block == nil {
+		b.WriteString(";")
+		return
+	}
+	b.WriteString("{")
+	switch r.Block.Kind {
+	case ast.RuleBlock:
+		s.write(b, r.Block.Rules)
+	case ast.DeclarationBlock:
+		s.writeDeclarations(b, r.Block.Declarations)
+	default:
+		b.WriteString(ast.Serialize(r.Block.Values))
+	}
+	b.WriteString("}")
+}
+
+func (s *source) writeDeclarations(b *bytes.Buffer, declarations []ast.Node) {
+	written := false
+	for _, node := range declarations {
+		switch n := node.(type) {
+		case *ast.Declaration:
+			if written { b.WriteString(";") }
+			written = true
+			b.WriteString(n.Property + ":" + ast.Serialize(n.Value))
+			if n.Important { b.WriteString("!important") }
+		case *ast.Comment:
+			b.WriteString(n.Text)
+		case *ast.AtRule:
+			s.writeAtRule(b, n)
+		}
+	}
+}
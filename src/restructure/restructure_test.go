@@ -0,0 +1,110 @@
+package restructure
+
+import (
+	"testing"
+)
+
+// Rules are only merged when no rule between them sets a property of the
+// same group, so the cascade stays as it was
+var mergeTests = []struct{ in, out string }{
+	{".a{color:red}.a{margin:0}", ".a{color:red;margin:0}"},
+	{".a{color:red}.b{color:red}", ".a,.b{color:red}"},
+	{".a{color:red}.a{color:red}", ".a{color:red}"},
+	{".a,.b,.a{color:red}", ".a,.b{color:red}"},
+	{".a,.b{color:red}.b,.a{margin:0}", ".a,.b{color:red;margin:0}"},
+	{".a{color:red}.b{margin:0}.a{padding:0}", ".a{color:red;padding:0}.b{margin:0}"},
+	{".a{color:red}.b{margin:0}.c{color:red}", ".a,.c{color:red}.b{margin:0}"},
+	// a rule between sets the same properties
+	{".a{color:red}.b{color:blue}.a{margin:0}", ".a{color:red;margin:0}.b{color:blue}"},
+	{".a{color:red}.b{color:blue}.a{color:green}", ".a{color:red}.b{color:blue}.a{color:green}"},
+	{".a{color:red}.b{color:blue}.c{color:red}", ".a{color:red}.b{color:blue}.c{color:red}"},
+	{".a{margin:0}.b{margin-left:1px}.c{margin:0}", ".a{margin:0}.b{margin-left:1px}.c{margin:0}"},
+	{".a{top:0}.b{inset:1px}.c{top:0}", ".a{top:0}.b{inset:1px}.c{top:0}"},
+	{".a{word-wrap:break-word}.b{overflow-wrap:normal}.c{word-wrap:break-word}", ".a{word-wrap:break-word}.b{overflow-wrap:normal}.c{word-wrap:break-word}"},
+	{".a{color:red}.b{all:unset}.a{margin:0}", ".a{color:red}.b{all:unset}.a{margin:0}"},
+	{".a{-webkit-box-sizing:border-box}.b{box-sizing:content-box}.c{-webkit-box-sizing:border-box}",
+		".a{-webkit-box-sizing:border-box}.b{box-sizing:content-box}.c{-webkit-box-sizing:border-box}"},
+	// a selector a browser doesn't know drops the whole rule
+	{".a:hover{color:red}.b::-moz-selection{color:red}", ".a:hover{color:red}.b::-moz-selection{color:red}"},
+	{".a::-moz-selection{color:red}.a::-moz-selection{margin:0}", ".a::-moz-selection{color:red;margin:0}"},
+	// at-rules aren't moved past
+	{".a{color:red}@media x{.a{margin:0}}.a{padding:0}", ".a{color:red}@media x{.a{margin:0}}.a{padding:0}"},
+	{"@media x{.a{color:red}.a{margin:0}}", "@media x{.a{color:red;margin:0}}"},
+	{"@keyframes k{0%{top:0}0%{left:0}}", "@keyframes k{0%{top:0}0%{left:0}}"},
+	{"@font-face{font-family:a}@font-face{font-family:b}", "@font-face{font-family:a}@font-face{font-family:b}"},
+	// rules written as they were, like those with hacks, stay where they are
+	{".a{*zoom:1}.a{color:red}", ".a{*zoom:1;color:red}"},
+	{".a{color:red;;}.a{margin:0}", ".a{color:red;;}.a{margin:0}"},
+	{".a{b}.a{margin:0}", ".a{b}.a{margin:0}"},
+	{".a/**/{color:red}.b{color:red}", ".a/**/{color:red}.b{color:red}"},
+	{"/*! c */.a{color:red}.a{margin:0}", "/*! c */.a{color:red;margin:0}"},
+}
+
+func TestMerge(t *testing.T) {
+	for _, test := range mergeTests {
+		if out := string(Restructure([]byte(test.in), true, false)); out != test.out { t.Errorf("%q: %q, want %q", test.in, out, test.out) }
+	}
+}
+
+// without merge, rules stay as they are
+func TestNoMerge(t *testing.T) {
+	for _, css := range []string{".a{color:red}.a{margin:0}", ".a{color:red}.b{color:red}", ".a,.a{color:red}"} {
+		if out := string(Restructure([]byte(css), false, false)); out != css { t.Errorf("%q: %q", css, out) }
+	}
+}
+
+// A declaration is only dropped when a later one in the block sets the same
+// property, or a longhand of it, to a value every browser understands or to
+// the same value
+var overrideTests = []struct{ in, out string }{
+	{".a{color:red;color:blue}", ".a{color:blue}"},
+	{".a{color:red;color:#00f}", ".a{color:#00f}"},
+	{".a{margin-left:1px;margin:0}", ".a{margin:0}"},
+	{".a{margin-left:1px;margin:0 auto 5% 1em}", ".a{margin:0 auto 5% 1em}"},
+	{".a{margin:0;margin-left:1px}", ".a{margin:0;margin-left:1px}"},
+	{".a{border-top-color:red;border-color:red blue}", ".a{border-color:red blue}"},
+	{".a{color:red!important;color:blue}", ".a{color:red!important}"},
+	{".a{color:red;color:blue!important}", ".a{color:blue!important}"},
+	{".a{width:1px;width:inherit}", ".a{width:inherit}"},
+	{".a{font-weight:bold;font-weight:700}", ".a{font-weight:700}"},
+	{".a{z-index:1;z-index:2}", ".a{z-index:2}"},
+	{"@media x{.a{top:0;top:1px}}", "@media x{.a{top:1px}}"},
+	{".a{background:url(a.png);background:url(a.png)}", ".a{background:url(a.png)}"},
+	{".a{-webkit-transition:none;transition:none}", ".a{-webkit-transition:none;transition:none}"},
+	{".a{--x:1;--X:2}", ".a{--x:1;--X:2}"},
+	{".a{*zoom:1;zoom:1}", ".a{*zoom:1;zoom:1}"},
+	// newer values
+	{".a{display:block;display:flex}", ".a{display:block;display:flex}"},
+	{".a{width:100px;width:calc(100% - 1px)}", ".a{width:100px;width:calc(100% - 1px)}"},
+	{".a{width:10px;width:1rem}", ".a{width:10px;width:1rem}"},
+	{".a{color:#000;color:#0008}", ".a{color:#000;color:#0008}"},
+	{".a{color:#000;color:rgba(0,0,0,.5)}", ".a{color:#000;color:rgba(0,0,0,.5)}"},
+	{".a{color:red;color:currentcolor}", ".a{color:red;color:currentcolor}"},
+	{".a{background:red;background:url(a.png)}", ".a{background:red;background:url(a.png)}"},
+	// obsolete and invalid values
+	{".a{cursor:pointer;cursor:hand}", ".a{cursor:pointer;cursor:hand}"},
+	{".a{width:1px;width:1px 2px}", ".a{width:1px;width:1px 2px}"},
+	{".a{margin:0;margin:1px 2px 3px 4px 5px}", ".a{margin:0;margin:1px 2px 3px 4px 5px}"},
+	{".a{margin:0;margin:1px inherit}", ".a{margin:0;margin:1px inherit}"},
+	{".a{color:red;color:bluish}", ".a{color:red;color:bluish}"},
+	{".a{font-weight:bold;font-weight:550}", ".a{font-weight:bold;font-weight:550}"},
+	// vendor prefixed values
+	{".a{display:-webkit-box;display:flex}", ".a{display:-webkit-box;display:flex}"},
+	{".a{display:block;display:-webkit-box}", ".a{display:block;display:-webkit-box}"},
+	{".a{cursor:pointer;cursor:-webkit-grab}", ".a{cursor:pointer;cursor:-webkit-grab}"},
+	{".a{width:100px;width:-moz-available}", ".a{width:100px;width:-moz-available}"},
+	{".a{position:relative;position:-webkit-sticky;position:sticky}", ".a{position:relative;position:-webkit-sticky;position:sticky}"},
+}
+
+func TestOverride(t *testing.T) {
+	for _, test := range overrideTests {
+		if out := string(Restructure([]byte(test.in), false, true)); out != test.out { t.Errorf("%q: %q, want %q", test.in, out, test.out) }
+	}
+}
+
+// without override, every declaration stays
+func TestNoOverride(t *testing.T) {
+	for _, test := range overrideTests {
+		if out := string(Restructure([]byte(test.in), false, false)); out != test.in { t.Errorf("%q: %q", test.in, out) }
+	}
+}
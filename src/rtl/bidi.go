@@ -0,0 +1,248 @@
+package rtl
+
+import (
+	"./lexer"
+	"strings"
+)
+
+// Rule whose declarations are sorted by whether they depend on the direction
+type split struct {
+	prelude []lexer.TokenValue
+	open    lexer.TokenValue
+	// the block as it was written, in case the rule can't be split
+	block   []lexer.TokenValue
+	shared  []lexer.TokenValue
+	ltr     []lexer.TokenValue
+	rtl     []lexer.TokenValue
+	// every declaration for each direction, in order
+	ltrAll  []lexer.TokenValue
+	rtlAll  []lexer.TokenValue
+	// families of the direction dependent declarations
+	split   map[string] bool
+	// a shared declaration may set what a direction dependent one before it
+	// does, the rule is written whole for each direction
+	whole   bool
+}
+
+// Flipper writing a single stylesheet for both directions. Rules with
+// declarations that depend on the direction are split in a shared rule and
+// rules scoped by prefix, in which %s is replaced by ltr or rtl.
+func CreateBidi(in chan(lexer.TokenValue), prefix string) (f *Flipper, out chan(lexer.TokenValue)) {
+	f, out = CreateFlipper(in)
+	f.Prefix = prefix
+	return
+}
+
+func (f *Flipper) prefix(dir string) []lexer.TokenValue {
+	return lexer.Tokenize(strings.Replace(f.Prefix, "%s", dir, -1))
+}
+
+func text(tokens []lexer.TokenValue) string {
+	s := make([]string, len(tokens))
+	for i, tv := range tokens {
+		s[i] = tv.Value
+	}
+	return strings.Join(s, "")
+}
+
+// position of the property and colon of a declaration
+func declarationParts(tokens []lexer.TokenValue) (i, colon int, ok bool) {
+	for i < len(tokens) && (tokens[i].Token == lexer.Whitespace || tokens[i].Token == lexer.Comment) {
+		i++
+	}
+	if i == len(tokens) || tokens[i].Token != lexer.Identifier { return }
+	colon = i + 1
+	for colon < len(tokens) && (tokens[colon].Token == lexer.Whitespace || tokens[colon].Token == lexer.Comment) {
+		colon++
+	}
+	return i, colon, colon < len(tokens) && tokens[colon].Token == lexer.Colon
+}
+
+// Properties of a family may set each other, like padding and padding-left or
+// background and background-position. Vendor prefixes are ignored.
+func family(tokens []lexer.TokenValue) string {
+	i, _, ok := declarationParts(tokens)
+	if !ok { return "" }
+	p := unprefixed(strings.ToLower(tokens[i].Value))
+	if i := strings.Index(p, "-"); i > 0 { p = p[:i] }
+	switch p {
+	case "left", "right", "top", "bottom":
+		return "inset"
+	}
+	return p
+}
+
+func significant(tokens []lexer.TokenValue) bool {
+	for _, tv := range tokens {
+		if tv.Token != lexer.Whitespace && tv.Token != lexer.Comment { return true }
+	}
+	return false
+}
+
+// whether the prelude starts a style rule rather than an at-rule
+func isRule(prelude []lexer.TokenValue) bool {
+	n := len(leading(prelude))
+	return n < len(prelude) && prelude[n].Token != lexer.AtKeyword
+}
+
+// whitespace and comments at the start of a statement
+func leading(statement []lexer.TokenValue) []lexer.TokenValue {
+	for i, tv := range statement {
+		if tv.Token != lexer.Whitespace && tv.Token != lexer.Comment { return statement[:i] }
+	}
+	return statement
+}
+
+// Puts prefix in front of every selector of the prelude
+func prefixed(prelude []lexer.TokenValue, prefix []lexer.TokenValue) (out []lexer.TokenValue) {
+	depth := 0
+	start := true
+	for _, tv := range prelude {
+		if start && tv.Token != lexer.Whitespace && tv.Token != lexer.Comment {
+			out = append(out, prefix...)
+			start = false
+		}
+		out = append(out, tv)
+		switch {
+		case isOpen(tv.Token):
+			depth++
+		case isClose(tv.Token):
+			depth--
+		case tv.Token == lexer.Comma && depth == 0:
+			start = true
+		}
+	}
+	return
+}
+
+// Prefixes the selectors of the style rules in tokens, including those
+// nested in at-rules
+func scope(tokens []lexer.TokenValue, prefix []lexer.TokenValue) (out []lexer.TokenValue) {
+	// whether each open block is a style rule or inside one
+	var rules []bool
+	start := 0
+	for i, tv := range tokens {
+		switch tv.Token {
+		case lexer.LeftBrace:
+			prelude := tokens[start:i]
+			inRule := len(rules) > 0 && rules[len(rules)-1]
+			rule := !inRule && isRule(prelude)
+			if rule {
+				out = append(out, prefixed(prelude, prefix)...)
+			} else {
+				out = append(out, prelude...)
+			}
+			out = append(out, tv)
+			rules = append(rules, rule || inRule)
+			start = i + 1
+		case lexer.RightBrace, lexer.Semicolon:
+			out = append(out, tokens[start:i+1]...)
+			if tv.Token == lexer.RightBrace && len(rules) > 0 { rules = rules[:len(rules)-1] }
+			start = i + 1
+		}
+	}
+	return append(out, tokens[start:]...)
+}
+
+// Sorts the declaration ending with end into the shared or the per
+// direction part of the rule being split
+func (f *Flipper) declaration(end lexer.TokenValue) {
+	r := f.rule
+	decl := f.statement
+	ignore := f.directives()
+	r.block = append(r.block, decl...)
+	r.block = append(r.block, end)
+	f.statement = f.statement[:0]
+
+	flipped := decl
+	if !ignore { flipped = Flip(decl) }
+	flipped = raw(flipped, true, nil)
+	// rtl:raw css is only written for the right to left direction
+	decl = withoutRaw(decl)
+	shared := text(flipped) == text(decl)
+	semicolon := lexer.TokenValue{Token: lexer.Semicolon, Value: ";", Pos: end.Pos}
+	if significant(decl) {
+		decl = terminated(decl, semicolon)
+		r.ltrAll = append(r.ltrAll, decl...)
+		if shared {
+			r.shared = append(r.shared, decl...)
+		} else {
+			r.ltr = append(r.ltr, decl...)
+		}
+	}
+	if significant(flipped) {
+		flipped = terminated(flipped, semicolon)
+		r.rtlAll = append(r.rtlAll, flipped...)
+		if !shared { r.rtl = append(r.rtl, flipped...) }
+	}
+
+	if shared {
+		// written before the direction dependent ones it has to follow
+		if g := family(decl); r.split[g] || (g == "all" && len(r.split) > 0) { r.whole = true }
+		return
+	}
+	if r.split == nil { r.split = make(map[string] bool) }
+	for _, g := range []string{family(decl), family(flipped)} {
+		if g != "" { r.split[g] = true }
+	}
+}
+
+// The tokens with end after them, unless they end in a ; already
+func terminated(tokens []lexer.TokenValue, end lexer.TokenValue) []lexer.TokenValue {
+	for i := len(tokens) - 1; i >= 0; i-- {
+		switch tokens[i].Token {
+		case lexer.Whitespace, lexer.Comment:
+			continue
+		case lexer.Semicolon:
+			return tokens
+		}
+		break
+	}
+	return append(append([]lexer.TokenValue(nil), tokens...), end)
+}
+
+func withoutRaw(tokens []lexer.TokenValue) (out []lexer.TokenValue) {
+	for _, tv := range tokens {
+		if tv.Token == lexer.Comment {
+			if d, _ := Directive(tv.Value); d == Raw { continue }
+		}
+		out = append(out, tv)
+	}
+	return
+}
+
+// Writes the shared rule followed by the rules for each direction, a rule
+// left empty by the split isn't written
+func (f *Flipper) writeRule(end lexer.TokenValue) {
+	r := f.rule
+	f.rule = nil
+	if r.whole {
+		r.ltr, r.rtl = r.ltrAll, r.rtlAll
+	} else if len(r.shared) > 0 || (len(r.ltr) == 0 && len(r.rtl) == 0) {
+		f.send(r.prelude)
+		f.Out <- r.open
+		f.send(r.shared)
+		f.Out <- end
+	}
+	if len(r.ltr) > 0 {
+		f.send(prefixed(r.prelude, f.prefix("ltr")))
+		f.Out <- r.open
+		f.send(r.ltr)
+		f.Out <- end
+	}
+	if len(r.rtl) > 0 {
+		f.send(prefixed(r.prelude, f.prefix("rtl")))
+		f.Out <- r.open
+		f.send(r.rtl)
+		f.Out <- end
+	}
+}
+
+// Writes the rule being split as it was, for rules with nested blocks
+func (f *Flipper) abort() {
+	r := f.rule
+	f.rule = nil
+	f.send(r.prelude)
+	f.Out <- r.open
+	f.send(r.block)
+}
@@ -0,0 +1,38 @@
+package rtl
+
+import (
+	"testing"
+)
+
+var bidiTests = []struct{ in, out string }{
+	{"a{color:red}", "a{color:red;}"},
+	{"a{float:left;color:red}", "a{color:red;}[dir=ltr] a{float:left;}[dir=rtl] a{float:right;}"},
+	{"a,b>c{margin:0 1px 0 2px}", "[dir=ltr] a,[dir=ltr] b>c{margin:0 1px 0 2px;}[dir=rtl] a,[dir=rtl] b>c{margin:0 2px 0 1px;}"},
+	{"@media x{a{float:left}}", "@media x{[dir=ltr] a{float:left;}[dir=rtl] a{float:right;}}"},
+	// neither keyframes nor declarations of other at-rules can be scoped
+	{"@keyframes x{to{left:0}}@font-face{src:url(left.woff)}", "@keyframes x{to{left:0}}@font-face{src:url(left.woff)}"},
+	{"a{b{float:left}}", "a{b{float:left}}"},
+	{"/*@noflip*/a{float:left}b{/*@noflip*/float:left}", "/*@noflip*/a{float:left}b{/*@noflip*/float:left;}"},
+	{"a{color:red;/*rtl:raw:float:left*/}", "a{color:red;}[dir=rtl] a{float:left;}"},
+	{"a{color:red;/*rtl:raw:float:left;*/}", "a{color:red;}[dir=rtl] a{float:left;}"},
+	{"/*rtl:raw:.x{float:left}*/a{b:c}", "[dir=rtl] .x{float:left}a{b:c;}"},
+	// a shared declaration setting what a direction dependent one before it
+	// does stays after it
+	{"a{padding:0;padding-left:5px}", "a{padding:0;}[dir=ltr] a{padding-left:5px;}[dir=rtl] a{padding-right:5px;}"},
+	{"a{padding-left:5px;padding:0}", "[dir=ltr] a{padding-left:5px;padding:0;}[dir=rtl] a{padding-right:5px;padding:0;}"},
+	{"a{float:left;float:none}", "[dir=ltr] a{float:left;float:none;}[dir=rtl] a{float:right;float:none;}"},
+	{"a{left:0;inset:auto;color:red}", "[dir=ltr] a{left:0;inset:auto;color:red;}[dir=rtl] a{right:0;inset:auto;color:red;}"},
+	{"a{background-position:20% 0;background:red}", "[dir=ltr] a{background-position:20% 0;background:red;}[dir=rtl] a{background-position:80% 0;background:red;}"},
+	{"a{border-top-left-radius:1px;-webkit-border-radius:0}", "[dir=ltr] a{border-top-left-radius:1px;-webkit-border-radius:0;}[dir=rtl] a{border-top-right-radius:1px;-webkit-border-radius:0;}"},
+	{"a{margin-left:1px;all:unset}", "[dir=ltr] a{margin-left:1px;all:unset;}[dir=rtl] a{margin-right:1px;all:unset;}"},
+	{"a{/*rtl:raw:float:left*/;float:none}", "[dir=ltr] a{float:none;}[dir=rtl] a{float:left;float:none;}"},
+	{"a{margin-left:1px;padding:0}", "a{padding:0;}[dir=ltr] a{margin-left:1px;}[dir=rtl] a{margin-right:1px;}"},
+}
+
+func TestBidi(t *testing.T) {
+	for _, test := range bidiTests {
+		if out := flipStream(test.in, "[dir=%s] "); out != test.out {
+			t.Errorf("%q: split to %q, want %q", test.in, out, test.out)
+		}
+	}
+}
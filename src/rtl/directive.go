@@ -0,0 +1,64 @@
+package rtl
+
+import (
+	"./lexer"
+	"strings"
+)
+
+// Directives understood in comments, as used by CSSJanus and rtlcss
+const (
+	NoDirective = iota
+	// /* @noflip */ or /* rtl:ignore */, the next rule or declaration isn't flipped
+	Ignore
+	// /* rtl:begin:ignore */ ... /* rtl:end:ignore */
+	BeginIgnore
+	EndIgnore
+	// /* rtl:raw: css */, css only written to the right to left output
+	Raw
+)
+
+// Directive in a comment and, for rtl:raw, the text following it
+func Directive(comment string) (directive int, arg string) {
+	s := comment
+	if strings.HasPrefix(s, "/*") { s = s[2:] }
+	if strings.HasSuffix(s, "*/") { s = s[:len(s)-2] }
+	s = strings.TrimSpace(strings.TrimLeft(s, "!"))
+	lower := strings.ToLower(s)
+	switch {
+	case lower == "@noflip" || lower == "rtl:ignore":
+		return Ignore, ""
+	case lower == "rtl:begin:ignore":
+		return BeginIgnore, ""
+	case lower == "rtl:end:ignore":
+		return EndIgnore, ""
+	case strings.HasPrefix(lower, "rtl:raw:"):
+		return Raw, strings.TrimSpace(s[len("rtl:raw:"):])
+	}
+	return NoDirective, ""
+}
+
+// Replaces rtl:raw comments with the css in them. Declarations in a block
+// are terminated so they can't run into the following one, rules outside
+// of one are prefixed with prefix when given.
+func raw(tokens []lexer.TokenValue, block bool, prefix []lexer.TokenValue) (out []lexer.TokenValue) {
+	for _, tv := range tokens {
+		if tv.Token != lexer.Comment {
+			out = append(out, tv)
+			continue
+		}
+		d, css := Directive(tv.Value)
+		if d != Raw {
+			out = append(out, tv)
+			continue
+		}
+		if block || prefix == nil {
+			out = append(out, lexer.Tokenize(css)...)
+		} else {
+			out = append(out, scope(lexer.Tokenize(css), prefix)...)
+		}
+		if block && css != "" && !strings.HasSuffix(css, ";") && !strings.HasSuffix(css, "}") {
+			out = append(out, lexer.TokenValue{Token: lexer.Semicolon, Value: ";", Pos: tv.Pos})
+		}
+	}
+	return
+}
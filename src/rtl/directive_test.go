@@ -0,0 +1,53 @@
+package rtl
+
+import (
+	"testing"
+)
+
+var directiveTests = []struct {
+	comment   string
+	directive int
+	arg       string
+}{
+	{"/* @noflip */", Ignore, ""},
+	{"/*!@NoFlip*/", Ignore, ""},
+	{"/*rtl:ignore*/", Ignore, ""},
+	{"/* rtl:begin:ignore */", BeginIgnore, ""},
+	{"/* rtl:end:ignore */", EndIgnore, ""},
+	{"/* rtl:raw: a{b:c} */", Raw, "a{b:c}"},
+	{"/*rtl:raw:*/", Raw, ""},
+	{"/* noflip */", NoDirective, ""},
+	{"/* rtl:ignore this */", NoDirective, ""},
+}
+
+func TestDirective(t *testing.T) {
+	for _, test := range directiveTests {
+		if d, arg := Directive(test.comment); d != test.directive || arg != test.arg {
+			t.Errorf("%q: directive %d %q, want %d %q", test.comment, d, arg, test.directive, test.arg)
+		}
+	}
+}
+
+var directiveStreamTests = []struct{ in, out string }{
+	// a declaration or a whole rule
+	{"a{/*@noflip*/float:left;clear:left}", "a{/*@noflip*/float:left;clear:right}"},
+	{"a{float:left/* rtl:ignore */;clear:left}", "a{float:left/* rtl:ignore */;clear:right}"},
+	{"/*@noflip*/a{float:left;b{c:left}}d{float:left}", "/*@noflip*/a{float:left;b{c:left}}d{float:right}"},
+	{"/*@noflip*/@media x{a{float:left}}b{float:left}", "/*@noflip*/@media x{a{float:left}}b{float:right}"},
+	// everything between the comments
+	{"/*rtl:begin:ignore*/a{float:left}b{float:left}/*rtl:end:ignore*/c{float:left}",
+		"/*rtl:begin:ignore*/a{float:left}b{float:left}/*rtl:end:ignore*/c{float:right}"},
+	{"a{/*rtl:begin:ignore*/float:left;clear:left;/*rtl:end:ignore*/padding-left:0}",
+		"a{/*rtl:begin:ignore*/float:left;clear:left;/*rtl:end:ignore*/padding-right:0}"},
+	// raw css replaces its comment, declarations get a ;
+	{"a{float:left;/*rtl:raw:color:red*/}", "a{float:right;color:red;}"},
+	{"/*rtl:raw:.x{float:left}*/a{b:c}", ".x{float:left}a{b:c}"},
+}
+
+func TestDirectives(t *testing.T) {
+	for _, test := range directiveStreamTests {
+		if out := flipStream(test.in, ""); out != test.out {
+			t.Errorf("%q: flipped to %q, want %q", test.in, out, test.out)
+		}
+	}
+}
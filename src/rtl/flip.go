@@ -0,0 +1,279 @@
+package rtl
+
+import (
+	"./lexer"
+	"strconv"
+	"strings"
+)
+
+// properties with top, right, bottom, left values
+var BOX_PROPERTIES = map[string] bool {
+	"margin":          true,
+	"padding":         true,
+	"border-width":    true,
+	"border-color":    true,
+	"border-style":    true,
+	"inset":           true,
+	"scroll-margin":   true,
+	"scroll-padding":  true,
+}
+
+var SHADOW_PROPERTIES = map[string] bool {
+	"box-shadow":  true,
+	"text-shadow": true,
+}
+
+var CURSORS = map[string] string {
+	"e-resize":    "w-resize",
+	"w-resize":    "e-resize",
+	"ne-resize":   "nw-resize",
+	"nw-resize":   "ne-resize",
+	"se-resize":   "sw-resize",
+	"sw-resize":   "se-resize",
+	"nesw-resize": "nwse-resize",
+	"nwse-resize": "nesw-resize",
+}
+
+var POSITIONS = map[string] bool {
+	"left":   true,
+	"right":  true,
+	"center": true,
+	"top":    true,
+	"bottom": true,
+}
+
+// parts of property names and values that are mirrored
+var SIDES = map[string] string {
+	"left":        "right",
+	"right":       "left",
+	"topleft":     "topright",
+	"topright":    "topleft",
+	"bottomleft":  "bottomright",
+	"bottomright": "bottomleft",
+}
+
+// index range of a top level component of a value
+type term struct {
+	start, end int
+}
+
+func isOpen(t lexer.Token) bool {
+	return t == lexer.Function || t == lexer.LeftParen || t == lexer.LeftBracket || t == lexer.LeftBrace
+}
+
+func isClose(t lexer.Token) bool {
+	return t == lexer.RightParen || t == lexer.RightBracket || t == lexer.RightBrace
+}
+
+// Top level terms of a value, in comma separated groups. A / is a term of
+// its own and !important isn't part of any term.
+func groups(value []lexer.TokenValue) (groups [][]term) {
+	var terms []term
+	depth := 0
+	open := -1
+	end := func(i int) {
+		if open >= 0 { terms = append(terms, term{open, i}) }
+		open = -1
+	}
+	i := 0
+	for ; i < len(value); i++ {
+		tv := value[i]
+		if depth > 0 {
+			switch {
+			case isOpen(tv.Token):
+				depth++
+			case isClose(tv.Token):
+				depth--
+			}
+			continue
+		}
+		switch {
+		case tv.Token == lexer.Whitespace || tv.Token == lexer.Comment:
+			end(i)
+		case tv.Token == lexer.Comma:
+			end(i)
+			groups = append(groups, terms)
+			terms = nil
+		case tv.Token == lexer.Bang:
+			end(i)
+			groups = append(groups, terms)
+			return
+		case isSlash(tv):
+			end(i)
+			terms = append(terms, term{i, i + 1})
+		default:
+			if open < 0 { open = i }
+			if isOpen(tv.Token) { depth++ }
+		}
+	}
+	end(i)
+	groups = append(groups, terms)
+	return
+}
+
+// Puts terms[order[n]] where terms[n] was. Slots beyond the last term are
+// appended, separated by a space.
+func reorder(value []lexer.TokenValue, terms []term, order []int) (out []lexer.TokenValue) {
+	last := terms[len(terms)-1]
+	out = append(out, value[:terms[0].start]...)
+	for n, i := range order {
+		t := terms[i]
+		out = append(out, value[t.start:t.end]...)
+		switch {
+		case n + 1 < len(terms):
+			out = append(out, value[terms[n].end:terms[n+1].start]...)
+		case n + 1 < len(order):
+			out = append(out, lexer.TokenValue{Token: lexer.Whitespace, Value: " ", Pos: value[t.start].Pos})
+		}
+	}
+	out = append(out, value[last.end:]...)
+	return
+}
+
+// top-left top-right bottom-right bottom-left
+func flipCorners(value []lexer.TokenValue, terms []term) []lexer.TokenValue {
+	switch len(terms) {
+	case 2:
+		return reorder(value, terms, []int{1, 0})
+	case 3:
+		return reorder(value, terms, []int{1, 0, 1, 2})
+	case 4:
+		return reorder(value, terms, []int{1, 0, 3, 2})
+	}
+	return value
+}
+
+func isSlash(tv lexer.TokenValue) bool {
+	return tv.Token == lexer.Delim && tv.Value == "/"
+}
+
+func isNumber(tv lexer.TokenValue) bool {
+	return tv.Token == lexer.Number || tv.Token == lexer.Dimension || tv.Token == lexer.Percentage
+}
+
+// a term of a background position
+func isPosition(tv lexer.TokenValue) bool {
+	return isNumber(tv) || tv.Token == lexer.Identifier && POSITIONS[strings.ToLower(tv.Value)]
+}
+
+func negate(tv lexer.TokenValue) lexer.TokenValue {
+	v := tv.Value
+	switch {
+	case strings.Trim(v[:len(v)-len(tv.Unit)], "+-0.") == "":
+		// zero
+	case v[0] == '-':
+		tv.Value = v[1:]
+	case v[0] == '+':
+		tv.Value = "-" + v[1:]
+	default:
+		tv.Value = "-" + v
+	}
+	return tv
+}
+
+// 20% from the left is 80% from the right
+func mirror(tv lexer.TokenValue) lexer.TokenValue {
+	f, err := strconv.Atof64(tv.Value[:len(tv.Value)-1])
+	if err != nil { return tv }
+	tv.Value = strconv.Ftoa64(100 - f, 'f', -1) + "%"
+	return tv
+}
+
+func unprefixed(property string) string {
+	if len(property) > 1 && property[0] == '-' && property[1] != '-' {
+		if i := strings.Index(property[1:], "-"); i >= 0 {
+			return property[i+2:]
+		}
+	}
+	return property
+}
+
+// padding-left, border-top-right-radius, -moz-border-radius-topleft
+func FlipProperty(property string) string {
+	if strings.HasPrefix(property, "--") { return property }
+	parts := strings.Split(property, "-")
+	for i, p := range parts {
+		if s, ok := SIDES[strings.ToLower(p)]; ok { parts[i] = s }
+	}
+	return strings.Join(parts, "-")
+}
+
+// Mirrors the value of a declaration, property is lower case and flipped already
+func FlipValue(property string, value []lexer.TokenValue) []lexer.TokenValue {
+	if strings.HasPrefix(property, "--") { return value }
+	value = append([]lexer.TokenValue(nil), value...)
+	name := unprefixed(property)
+
+	for i, tv := range value {
+		if tv.Token != lexer.Identifier { continue }
+		v := strings.ToLower(tv.Value)
+		switch {
+		case v == "left" || v == "right":
+			value[i].Value = SIDES[v]
+		case name == "direction" && v == "ltr":
+			value[i].Value = "rtl"
+		case name == "direction" && v == "rtl":
+			value[i].Value = "ltr"
+		case name == "cursor" && CURSORS[v] != "":
+			value[i].Value = CURSORS[v]
+		}
+	}
+
+	gs := groups(value)
+	switch {
+	case BOX_PROPERTIES[name]:
+		// top right bottom left -> top left bottom right
+		if terms := gs[0]; len(gs) == 1 && len(terms) == 4 {
+			value = reorder(value, terms, []int{0, 3, 2, 1})
+		}
+	case name == "border-radius":
+		if len(gs) != 1 { break }
+		terms := gs[0]
+		slash := len(terms)
+		for n, t := range terms {
+			if isSlash(value[t.start]) { slash = n }
+		}
+		// horizontal and vertical radii are flipped separately, the second
+		// part first so the terms of the first one stay where they are
+		if slash < len(terms) { value = flipCorners(value, terms[slash+1:]) }
+		value = flipCorners(value, terms[:slash])
+	case SHADOW_PROPERTIES[name]:
+		// the first length of each shadow is the horizontal offset
+		for _, terms := range gs {
+			for _, t := range terms {
+				if t.end - t.start == 1 && isNumber(value[t.start]) {
+					value[t.start] = negate(value[t.start])
+					break
+				}
+			}
+		}
+	case name == "background" || name == "background-position" || name == "background-position-x":
+		// a percentage is mirrored when it's the horizontal position, which
+		// only the first term of a position can be. Lengths can't be without
+		// knowing the size, offsets from an edge stay as they are.
+		for _, terms := range gs {
+			for _, t := range terms {
+				tv := value[t.start]
+				if isSlash(tv) { break }
+				if t.end - t.start != 1 || !isPosition(tv) { continue }
+				if tv.Token == lexer.Percentage { value[t.start] = mirror(tv) }
+				break
+			}
+		}
+	}
+	return value
+}
+
+// Flips a declaration for right to left languages, anything that isn't a
+// declaration is returned unchanged
+func Flip(tokens []lexer.TokenValue) []lexer.TokenValue {
+	i, colon, ok := declarationParts(tokens)
+	if !ok { return tokens }
+
+	property := tokens[i]
+	property.Value = FlipProperty(property.Value)
+	out := append([]lexer.TokenValue(nil), tokens[:i]...)
+	out = append(out, property)
+	out = append(out, tokens[i+1:colon+1]...)
+	return append(out, FlipValue(strings.ToLower(property.Value), tokens[colon+1:])...)
+}
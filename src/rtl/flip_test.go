@@ -0,0 +1,76 @@
+package rtl
+
+import (
+	"./lexer"
+	"bytes"
+	"testing"
+)
+
+func join(tokens []lexer.TokenValue) string {
+	var b bytes.Buffer
+	for _, tv := range tokens {
+		b.WriteString(tv.Value)
+	}
+	return b.String()
+}
+
+var flipTests = []struct{ in, out string }{
+	{"float:left", "float:right"},
+	{"text-align: RIGHT !important", "text-align: left !important"},
+	{"padding-left:1px", "padding-right:1px"},
+	{"-moz-border-radius-topleft:3px", "-moz-border-radius-topright:3px"},
+	{"border-top-right-radius:3px", "border-top-left-radius:3px"},
+	{"--my-left:left", "--my-left:left"},
+	{"direction:ltr", "direction:rtl"},
+	{"cursor:ne-resize", "cursor:nw-resize"},
+	{"cursor:nwse-resize", "cursor:nesw-resize"},
+	{"clear:both", "clear:both"},
+	// top right bottom left
+	{"margin:1px 2px 3px 4px", "margin:1px 4px 3px 2px"},
+	{"padding:1px 2px 3px", "padding:1px 2px 3px"},
+	{"border-color:red #010203 #00f green !important", "border-color:red green #00f #010203 !important"},
+	{"border-width:1px 2px 3px calc(1px + 2px)", "border-width:1px calc(1px + 2px) 3px 2px"},
+	// top-left top-right bottom-right bottom-left
+	{"border-radius:1px 2px", "border-radius:2px 1px"},
+	{"border-radius:1px 2px 3px", "border-radius:2px 1px 2px 3px"},
+	{"border-radius:1px 2px 3px 4px", "border-radius:2px 1px 4px 3px"},
+	{"border-radius:1px 2px 3px/4px 5px 6px 7px", "border-radius:2px 1px 2px 3px/5px 4px 7px 6px"},
+	{"border-radius:1px/2px 3px", "border-radius:1px/3px 2px"},
+	{"box-shadow:2px 3px red,inset -1px 0 blue", "box-shadow:-2px 3px red,inset 1px 0 blue"},
+	{"text-shadow:0 1px #000", "text-shadow:0 1px #000"},
+	{"text-shadow:+1px 1px #000", "text-shadow:-1px 1px #000"},
+	// only a percentage in the horizontal position is mirrored
+	{"background-position:20% 50%", "background-position:80% 50%"},
+	{"background-position:20% 30%", "background-position:80% 30%"},
+	{"background-position:left 20%", "background-position:right 20%"},
+	{"background-position:right 10px top", "background-position:left 10px top"},
+	{"background-position:right 10% top 5%", "background-position:left 10% top 5%"},
+	{"background-position:top 20% left", "background-position:top 20% right"},
+	{"background-position:center 20%", "background-position:center 20%"},
+	{"background-position:10px 20%", "background-position:10px 20%"},
+	{"background-position:25%", "background-position:75%"},
+	{"background-position:20% 30%,left 10%,5% 0", "background-position:80% 30%,right 10%,95% 0"},
+	{"background-position-x:25%", "background-position-x:75%"},
+	{"background-position-y:25%", "background-position-y:25%"},
+	// names in urls stay
+	{"background:url(left.png) 0 0/30% no-repeat", "background:url(left.png) 0 0/30% no-repeat"},
+	{"background:url(a.png) no-repeat 20% 0/30% auto,red", "background:url(a.png) no-repeat 80% 0/30% auto,red"},
+	{"background:url(a.png) left 20%,url(b.png) 10% 0", "background:url(a.png) right 20%,url(b.png) 90% 0"},
+	{"background:#fff url(a.png) repeat-y 12.5% 0", "background:#fff url(a.png) repeat-y 87.5% 0"},
+}
+
+func TestFlip(t *testing.T) {
+	for _, test := range flipTests {
+		if out := join(Flip(lexer.Tokenize(test.in))); out != test.out {
+			t.Errorf("%q: flipped to %q, want %q", test.in, out, test.out)
+		}
+	}
+}
+
+func TestFlipNotADeclaration(t *testing.T) {
+	for _, in := range []string{"a", "", "@import 'left.css'", ":left"} {
+		if out := join(Flip(lexer.Tokenize(in))); out != in {
+			t.Errorf("%q: flipped to %q", in, out)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package rtl
+
+import (
+	"./lexer"
+	"strings"
+)
+
+// What CreateLogical rewrites to
+const (
+	// physical properties of a left to right page
+	LeftToRight = iota
+	// physical properties of a right to left page
+	RightToLeft
+	// logical properties, the input is taken to be left to right
+	ToLogical
+)
+
+// properties with inline and block variants, border-inline-start-width etc
+var LOGICAL_BOXES = map[string] bool {
+	"margin":         true,
+	"padding":        true,
+	"inset":          true,
+	"border":         true,
+	"scroll-margin":  true,
+	"scroll-padding": true,
+}
+
+var BORDER_PARTS = map[string] bool {
+	"":       true,
+	"-width": true,
+	"-style": true,
+	"-color": true,
+}
+
+// horizontal writing mode only
+var LOGICAL_SIZES = map[string] string {
+	"inline-size":     "width",
+	"block-size":      "height",
+	"min-inline-size": "min-width",
+	"min-block-size":  "min-height",
+	"max-inline-size": "max-width",
+	"max-block-size":  "max-height",
+	"overflow-inline": "overflow-x",
+	"overflow-block":  "overflow-y",
+}
+
+// logical values per property, start sides are on the left
+var LOGICAL_VALUES = map[string] map[string] string {
+	"float":           {"inline-start": "left", "inline-end": "right"},
+	"clear":           {"inline-start": "left", "inline-end": "right"},
+	"text-align":      {"start": "left", "end": "right"},
+	"text-align-last": {"start": "left", "end": "right"},
+	"resize":          {"inline": "horizontal", "block": "vertical"},
+}
+
+var PHYSICAL_VALUES = map[string] map[string] string {
+	"float":           {"left": "inline-start", "right": "inline-end"},
+	"clear":           {"left": "inline-start", "right": "inline-end"},
+	"text-align":      {"left": "start", "right": "end"},
+	"text-align-last": {"left": "start", "right": "end"},
+}
+
+// margin-inline-start is margin, inline, start and border-block-color is
+// border, block, "" and -color
+func logicalParts(property string) (box, axis, edge, part string, ok bool) {
+	for _, a := range []string{"-inline", "-block"} {
+		i := strings.Index(property, a)
+		if i < 0 { continue }
+		box = property[:i]
+		rest := property[i+len(a):]
+		switch {
+		case strings.HasPrefix(rest, "-start"):
+			edge, rest = "start", rest[len("-start"):]
+		case strings.HasPrefix(rest, "-end"):
+			edge, rest = "end", rest[len("-end"):]
+		}
+		if !LOGICAL_BOXES[box] || (rest != "" && box != "border") || !BORDER_PARTS[rest] { return }
+		return box, a[1:], edge, rest, true
+	}
+	return
+}
+
+func side(axis, edge string, dir int) string {
+	switch {
+	case axis == "block" && edge == "start":
+		return "top"
+	case axis == "block":
+		return "bottom"
+	case (edge == "start") == (dir == LeftToRight):
+		return "left"
+	}
+	return "right"
+}
+
+func physical(box, side, part string) string {
+	if box == "inset" { return side }
+	return box + "-" + side + part
+}
+
+// border-start-end-radius is block start and inline end
+func radiusParts(property string) (block, inline string, ok bool) {
+	if len(property) <= len("border--radius") || !strings.HasPrefix(property, "border-") || !strings.HasSuffix(property, "-radius") { return }
+	edges := strings.Split(property[len("border-"):len(property)-len("-radius")], "-")
+	if len(edges) != 2 { return }
+	for _, e := range edges {
+		if e != "start" && e != "end" { return }
+	}
+	return edges[0], edges[1], true
+}
+
+// the value of each declaration of an axis shorthand, margin-inline: 1px 2px
+// is 1px on the start side and 2px on the end
+func axisValues(box, part string, value []lexer.TokenValue) (start, end []lexer.TokenValue, ok bool) {
+	gs := groups(value)
+	if len(gs) != 1 || len(gs[0]) == 0 { return }
+	terms := gs[0]
+	// everything after the terms, !important
+	last := terms[len(terms)-1]
+	tail := value[last.end:]
+	for len(tail) > 0 && tail[0].Token == lexer.Whitespace {
+		tail = tail[1:]
+	}
+	lead := value[:terms[0].start]
+	declaration := func(t term) (v []lexer.TokenValue) {
+		v = append(v, lead...)
+		v = append(v, value[t.start:t.end]...)
+		if len(tail) > 0 {
+			v = append(v, lexer.TokenValue{Token: lexer.Whitespace, Value: " ", Pos: tail[0].Pos})
+			v = append(v, tail...)
+		}
+		return v
+	}
+	switch {
+	case box == "border" && part == "":
+		// border-inline: 1px solid is a border for both sides
+		start = declaration(term{terms[0].start, last.end})
+		return start, start, true
+	case hasVar(value, terms):
+		// a var() may hold both values
+		return
+	case len(terms) == 1:
+		start = declaration(terms[0])
+		return start, start, true
+	case len(terms) == 2:
+		return declaration(terms[0]), declaration(terms[1]), true
+	}
+	return
+}
+
+func hasVar(value []lexer.TokenValue, terms []term) bool {
+	for _, t := range terms {
+		if tv := value[t.start]; tv.Token == lexer.Function && strings.ToLower(tv.Value) == "var(" { return true }
+	}
+	return false
+}
+
+func rewriteValue(values map[string] string, value []lexer.TokenValue) []lexer.TokenValue {
+	if values == nil { return value }
+	value = append([]lexer.TokenValue(nil), value...)
+	for i, tv := range value {
+		if tv.Token != lexer.Identifier { continue }
+		if v, ok := values[strings.ToLower(tv.Value)]; ok { value[i].Value = v }
+	}
+	return value
+}
+
+// Replaces logical properties and values of a declaration with the physical
+// ones for dir, LeftToRight or RightToLeft. Shorthands of an axis become two
+// declarations separated by a semicolon.
+func Lower(tokens []lexer.TokenValue, dir int) []lexer.TokenValue {
+	i, colon, ok := declarationParts(tokens)
+	if !ok { return tokens }
+	property := strings.ToLower(tokens[i].Value)
+	value := tokens[colon+1:]
+	rename := func(name string, value []lexer.TokenValue) (out []lexer.TokenValue) {
+		tv := tokens[i]
+		tv.Value = name
+		out = append(out, tokens[:i]...)
+		out = append(out, tv)
+		out = append(out, tokens[i+1:colon+1]...)
+		return append(out, value...)
+	}
+
+	if name, ok := LOGICAL_SIZES[property]; ok { return rename(name, value) }
+	if block, inline, ok := radiusParts(property); ok {
+		return rename("border-" + side("block", block, dir) + "-" + side("inline", inline, dir) + "-radius", value)
+	}
+	if box, axis, edge, part, ok := logicalParts(property); ok {
+		if edge != "" { return rename(physical(box, side(axis, edge, dir), part), value) }
+		start, end, ok := axisValues(box, part, value)
+		if !ok { return tokens }
+		out := rename(physical(box, side(axis, "start", dir), part), start)
+		out = append(out, lexer.TokenValue{Token: lexer.Semicolon, Value: ";", Pos: tokens[i].Pos})
+		tv := tokens[i]
+		tv.Value = physical(box, side(axis, "end", dir), part)
+		out = append(out, tv)
+		out = append(out, tokens[i+1:colon+1]...)
+		return append(out, end...)
+	}
+
+	values := LOGICAL_VALUES[property]
+	if dir == RightToLeft && values != nil {
+		flipped := make(map[string] string)
+		for k, v := range values {
+			if s, ok := SIDES[v]; ok { v = s }
+			flipped[k] = v
+		}
+		values = flipped
+	}
+	return rename(tokens[i].Value, rewriteValue(values, value))
+}
+
+// Replaces the physical properties and values of a left to right declaration
+// with logical ones
+func Raise(tokens []lexer.TokenValue) []lexer.TokenValue {
+	i, colon, ok := declarationParts(tokens)
+	if !ok { return tokens }
+	property := strings.ToLower(tokens[i].Value)
+	if strings.HasPrefix(property, "--") { return tokens }
+
+	name := tokens[i].Value
+	parts := strings.Split(property, "-")
+	edges := map[string] string{"left": "inline-start", "right": "inline-end", "top": "block-start", "bottom": "block-end"}
+	switch {
+	case len(parts) == 1 && edges[property] != "":
+		// left is inset-inline-start
+		name = "inset-" + edges[property]
+	case len(parts) == 4 && parts[0] == "border" && parts[3] == "radius" && edges[parts[1]] != "" && edges[parts[2]] != "":
+		// border-top-left-radius is border-start-start-radius
+		block, inline := edges[parts[1]], edges[parts[2]]
+		if !strings.HasPrefix(block, "block-") || !strings.HasPrefix(inline, "inline-") { break }
+		name = "border-" + block[len("block-"):] + "-" + inline[len("inline-"):] + "-radius"
+	default:
+		for n := 1; n < len(parts); n++ {
+			edge := edges[parts[n]]
+			box := strings.Join(parts[:n], "-")
+			part := strings.Join(parts[n+1:], "-")
+			if part != "" { part = "-" + part }
+			if edge == "" || !LOGICAL_BOXES[box] || box == "inset" { continue }
+			if part != "" && (box != "border" || !BORDER_PARTS[part]) { continue }
+			name = box + "-" + edge + part
+			break
+		}
+	}
+
+	tv := tokens[i]
+	tv.Value = name
+	out := append([]lexer.TokenValue(nil), tokens[:i]...)
+	out = append(out, tv)
+	out = append(out, tokens[i+1:colon+1]...)
+	return append(out, rewriteValue(PHYSICAL_VALUES[property], tokens[colon+1:])...)
+}
+
+// Rewrites the declarations of the token stream between logical and
+// physical properties
+type Logical struct {
+	In        chan(lexer.TokenValue)
+	Out       chan(lexer.TokenValue)
+	Mode      int
+	statement []lexer.TokenValue
+	depth     int
+}
+
+func CreateLogical(in chan(lexer.TokenValue), mode int) (l *Logical, out chan(lexer.TokenValue)) {
+	out = make(chan(lexer.TokenValue), lexer.BUFFER)
+	l = &Logical{In: in, Out: out, Mode: mode}
+	return
+}
+
+func (l *Logical) flush() {
+	statement := l.statement
+	if l.depth > 0 {
+		if l.Mode == ToLogical {
+			statement = Raise(statement)
+		} else {
+			statement = Lower(statement, l.Mode)
+		}
+	}
+	for _, tv := range statement {
+		l.Out <- tv
+	}
+	l.statement = l.statement[:0]
+}
+
+func (l *Logical) Run() {
+	var tv lexer.TokenValue
+	for {
+		tv = <- l.In
+		switch tv.Token {
+		case lexer.EndToken:
+			l.flush()
+			l.Out <- tv
+			return
+		case lexer.LeftBrace:
+			// selectors and at-rule preludes are passed on as they are
+			for _, s := range l.statement {
+				l.Out <- s
+			}
+			l.statement = l.statement[:0]
+			l.Out <- tv
+			l.depth++
+		case lexer.Semicolon, lexer.RightBrace:
+			l.flush()
+			l.Out <- tv
+			if tv.Token == lexer.RightBrace && l.depth > 0 { l.depth-- }
+		default:
+			l.statement = append(l.statement, tv)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package rtl
+
+import (
+	"./lexer"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+var lowerTests = []struct{ in, ltr, rtl string }{
+	{"margin-inline-start:1px", "margin-left:1px", "margin-right:1px"},
+	{"padding-block-end:1px", "padding-bottom:1px", "padding-bottom:1px"},
+	{"inset-inline-end:0", "right:0", "left:0"},
+	{"border-inline-start-color:red", "border-left-color:red", "border-right-color:red"},
+	{"border-block-start:1px solid", "border-top:1px solid", "border-top:1px solid"},
+	{"margin-inline:1px 2px", "margin-left:1px;margin-right:2px", "margin-right:1px;margin-left:2px"},
+	{"padding-block:1px !important", "padding-top:1px !important;padding-bottom:1px !important", "padding-top:1px !important;padding-bottom:1px !important"},
+	{"border-inline:1px solid red", "border-left:1px solid red;border-right:1px solid red", "border-right:1px solid red;border-left:1px solid red"},
+	{"margin-inline:1px 2px 3px", "margin-inline:1px 2px 3px", "margin-inline:1px 2px 3px"},
+	// a var() may hold one value or two
+	{"margin-inline:var(--m)", "margin-inline:var(--m)", "margin-inline:var(--m)"},
+	{"padding-block:VAR(--a) 1px", "padding-block:VAR(--a) 1px", "padding-block:VAR(--a) 1px"},
+	{"border-inline-width:var(--w)", "border-inline-width:var(--w)", "border-inline-width:var(--w)"},
+	{"border-inline:var(--b)", "border-left:var(--b);border-right:var(--b)", "border-right:var(--b);border-left:var(--b)"},
+	{"margin-inline:calc(var(--m) * 2)", "margin-left:calc(var(--m) * 2);margin-right:calc(var(--m) * 2)", "margin-right:calc(var(--m) * 2);margin-left:calc(var(--m) * 2)"},
+	{"margin-inline-start:var(--m)", "margin-left:var(--m)", "margin-right:var(--m)"},
+	// block start and inline end
+	{"border-start-start-radius:1px", "border-top-left-radius:1px", "border-top-right-radius:1px"},
+	{"border-start-end-radius:1px", "border-top-right-radius:1px", "border-top-left-radius:1px"},
+	{"border-end-start-radius:1px 2px", "border-bottom-left-radius:1px 2px", "border-bottom-right-radius:1px 2px"},
+	{"border-end-end-radius:1px", "border-bottom-right-radius:1px", "border-bottom-left-radius:1px"},
+	{"border-start-radius:1px", "border-start-radius:1px", "border-start-radius:1px"},
+	{"inline-size:1px", "width:1px", "width:1px"},
+	{"max-block-size:1px", "max-height:1px", "max-height:1px"},
+	{"float:inline-start", "float:left", "float:right"},
+	{"text-align:end", "text-align:right", "text-align:left"},
+	{"resize:inline", "resize:horizontal", "resize:horizontal"},
+	{"margin-inline-start-x:1px", "margin-inline-start-x:1px", "margin-inline-start-x:1px"},
+	{"color:red", "color:red", "color:red"},
+}
+
+func TestLower(t *testing.T) {
+	for _, test := range lowerTests {
+		for dir, want := range []string{test.ltr, test.rtl} {
+			if out := join(Lower(lexer.Tokenize(test.in), dir)); out != want {
+				t.Errorf("%q: lowered to %q for %d, want %q", test.in, out, dir, want)
+			}
+		}
+	}
+}
+
+var raiseTests = []struct{ in, out string }{
+	{"margin-left:1px", "margin-inline-start:1px"},
+	{"padding-top:1px", "padding-block-start:1px"},
+	{"border-right-width:1px", "border-inline-end-width:1px"},
+	{"border-bottom:0", "border-block-end:0"},
+	{"left:0", "inset-inline-start:0"},
+	{"bottom:0", "inset-block-end:0"},
+	{"border-top-left-radius:1px", "border-start-start-radius:1px"},
+	{"border-top-right-radius:1px", "border-start-end-radius:1px"},
+	{"border-bottom-left-radius:1px 2px", "border-end-start-radius:1px 2px"},
+	{"border-bottom-right-radius:1px", "border-end-end-radius:1px"},
+	{"border-left-top-radius:1px", "border-left-top-radius:1px"},
+	{"float:left", "float:inline-start"},
+	{"text-align:right", "text-align:end"},
+	{"margin:1px 2px", "margin:1px 2px"},
+	{"--left:1px", "--left:1px"},
+	{"width:1px", "width:1px"},
+}
+
+func TestRaise(t *testing.T) {
+	for _, test := range raiseTests {
+		if out := join(Raise(lexer.Tokenize(test.in))); out != test.out {
+			t.Errorf("%q: raised to %q, want %q", test.in, out, test.out)
+		}
+	}
+}
+
+// raising and lowering for left to right gives the physical property back
+func TestRaiseLower(t *testing.T) {
+	for _, test := range raiseTests {
+		if strings.HasPrefix(test.in, "--") { continue }
+		if out := join(Lower(Raise(lexer.Tokenize(test.in)), LeftToRight)); out != test.in {
+			t.Errorf("%q: raised and lowered to %q", test.in, out)
+		}
+	}
+}
+
+func TestLogical(t *testing.T) {
+	for _, test := range []struct {
+		in   string
+		mode int
+		out  string
+	}{
+		{".start{margin-inline:1px 2px;float:inline-end}", LeftToRight, ".start{margin-left:1px;margin-right:2px;float:right}"},
+		{"@media (inline-size:1px){a{inline-size:1px}}", RightToLeft, "@media (inline-size:1px){a{width:1px}}"},
+		{"a{border-top-left-radius:1px;left:0}", ToLogical, "a{border-start-start-radius:1px;inset-inline-start:0}"},
+	} {
+		l, out := CreateLogical(lex(test.in), test.mode)
+		go l.Run()
+		var b bytes.Buffer
+		for tv := <- out; tv.Token != lexer.EndToken; tv = <- out {
+			b.WriteString(tv.Value)
+		}
+		if b.String() != test.out {
+			t.Errorf("%q: rewritten to %q, want %q", test.in, b.String(), test.out)
+		}
+	}
+}
@@ -7,26 +7,163 @@ package rtl
 import (
 	"./lexer"
 	"os"
+	"strings"
 )
 
+// Flips the token stream for right to left languages
+type Flipper struct {
+	In        chan(lexer.TokenValue)
+	Out       chan(lexer.TokenValue)
+	statement []lexer.TokenValue
+	depth     int
+	// depth of the block of a rule that isn't flipped
+	skip      int
+	// inside rtl:begin:ignore
+	ignoring  bool
+	// selector prefix for bidirectional output, see CreateBidi
+	Prefix    string
+	rule      *split
+	// depth of the block of @keyframes, their rules can't be scoped
+	frames    int
+}
+
+func CreateFlipper(in chan(lexer.TokenValue)) (f *Flipper, out chan(lexer.TokenValue)) {
+	out = make(chan(lexer.TokenValue), lexer.BUFFER)
+	f = &Flipper{In: in, Out: out}
+	return
+}
+
+func isKeyframes(prelude []lexer.TokenValue) bool {
+	for _, tv := range prelude {
+		switch tv.Token {
+		case lexer.Whitespace, lexer.Comment:
+		case lexer.AtKeyword:
+			return strings.HasSuffix(strings.ToLower(tv.Value), "keyframes")
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+func (f *Flipper) send(tokens []lexer.TokenValue) {
+	for _, tv := range tokens {
+		f.Out <- tv
+	}
+}
+
+// Applies the directives in the statement, returns whether the statement
+// is excluded from flipping
+func (f *Flipper) directives() (ignore bool) {
+	for _, tv := range f.statement {
+		if tv.Token != lexer.Comment { continue }
+		switch d, _ := Directive(tv.Value); d {
+		case Ignore:
+			ignore = true
+		case BeginIgnore:
+			f.ignoring = true
+		case EndIgnore:
+			f.ignoring = false
+		}
+	}
+	return ignore || f.ignoring
+}
+
+// rtl:raw rules only apply to the right to left direction
+func (f *Flipper) rawScope() []lexer.TokenValue {
+	if f.Prefix == "" { return nil }
+	return f.prefix("rtl")
+}
+
+// statements inside a block that don't open one are declarations, in
+// bidirectional output only those of split rules are flipped
+func (f *Flipper) flush() {
+	statement := f.statement
+	if ignore := f.directives(); f.Prefix == "" && f.depth > 0 && f.skip == 0 && !ignore {
+		statement = Flip(statement)
+	}
+	f.send(raw(statement, f.depth > 0, f.rawScope()))
+	f.statement = f.statement[:0]
+}
+
+func (f *Flipper) Run() {
+	var tv lexer.TokenValue
+	for {
+		tv = <- f.In
+		switch tv.Token {
+		case lexer.EndToken:
+			if f.rule != nil { f.abort() }
+			f.flush()
+			f.Out <- tv
+			return
+		case lexer.LeftBrace:
+			// selector or at-rule prelude
+			ignore := f.directives()
+			switch {
+			case f.rule != nil:
+				// nested block, the rule is written as it is
+				f.abort()
+				f.send(f.statement)
+				f.Out <- tv
+			case f.Prefix != "" && !ignore && f.skip == 0 && f.frames == 0 && isRule(f.statement):
+				// rtl:raw comments before the rule are written on their own
+				lead := leading(f.statement)
+				f.send(raw(lead, false, f.rawScope()))
+				f.rule = &split{prelude: append([]lexer.TokenValue(nil), f.statement[len(lead):]...), open: tv}
+			default:
+				f.send(raw(f.statement, false, f.rawScope()))
+				f.Out <- tv
+			}
+			if f.frames == 0 && isKeyframes(f.statement) { f.frames = f.depth + 1 }
+			f.statement = f.statement[:0]
+			f.depth++
+			if ignore && f.skip == 0 { f.skip = f.depth }
+		case lexer.Semicolon, lexer.RightBrace:
+			if f.rule != nil {
+				f.declaration(tv)
+				if tv.Token == lexer.RightBrace { f.writeRule(tv) }
+			} else {
+				f.flush()
+				f.Out <- tv
+			}
+			if tv.Token == lexer.RightBrace && f.depth > 0 {
+				f.depth--
+				if f.depth < f.skip { f.skip = 0 }
+				if f.depth < f.frames { f.frames = 0 }
+			}
+		default:
+			f.statement = append(f.statement, tv)
+		}
+	}
+}
+
+// Writes the token stream flipped for right to left languages
 type Converter struct {
 	In  chan(lexer.TokenValue)
 	Out *os.File
+	Eof chan(int)
+	// first error writing to Out
+	Err os.Error
 }
 
-func CreateConverter(in chan(lexer.TokenValue), file *os.File) (c *Converter) {
-	c = &Converter{In: in, Out: file}
+func CreateConverter(in chan(lexer.TokenValue), file *os.File) (c *Converter, eof chan(int)) {
+	eof = make(chan(int))
+	c = &Converter{In: in, Out: file, Eof: eof}
 	return
 }
 
 func (c *Converter) Run() {
+	flipper, flipped := CreateFlipper(c.In)
+	go flipper.Run()
+
 	var tv lexer.TokenValue
 	for {
-		tv = <- c.In
+		tv = <- flipped
 		if tv.Token == lexer.EndToken {
+			if c.Eof != nil { c.Eof <- 0 }
 			return
-		} else {
-			c.Out.WriteString(tv.Value)
+		} else if _, err := c.Out.WriteString(tv.Value); err != nil && c.Err == nil {
+			c.Err = err
 		}
 	}
 }
@@ -0,0 +1,63 @@
+package rtl
+
+import (
+	"./lexer"
+	"bytes"
+	"testing"
+)
+
+// tokens of css, sent by a lexer running in a goroutine of its own
+func lex(css string) chan(lexer.TokenValue) {
+	in := make(chan(int))
+	lex, out := lexer.CreateLexer(in)
+	go func() {
+		for _, c := range css {
+			in <- c
+		}
+		in <- -1
+	}()
+	go lex.Run()
+	return out
+}
+
+// css flipped by a Flipper, or a bidirectional one when prefix is given
+func flipStream(css, prefix string) string {
+	f, out := CreateBidi(lex(css), prefix)
+	go f.Run()
+	var b bytes.Buffer
+	for tv := <- out; tv.Token != lexer.EndToken; tv = <- out {
+		b.WriteString(tv.Value)
+	}
+	return b.String()
+}
+
+var streamTests = []struct{ in, out string }{
+	{"a{float:left}", "a{float:right}"},
+	// selectors, at-rule preludes and values outside of blocks stay
+	{".left > a[dir=left]{margin-left:1px;padding:1px 2px 3px 4px}", ".left > a[dir=left]{margin-right:1px;padding:1px 4px 3px 2px}"},
+	{"@import 'left.css' left;a{left:0}", "@import 'left.css' left;a{right:0}"},
+	{"@media (min-width:1px){a{float:left}b{clear:right}}", "@media (min-width:1px){a{float:right}b{clear:left}}"},
+	{"@font-face{font-family:left;src:url(left.woff)}", "@font-face{font-family:right;src:url(left.woff)}"},
+	{"@keyframes x{from{left:0}to{left:10px}}", "@keyframes x{from{right:0}to{right:10px}}"},
+	{"/* left */\na {\n  float: left; /* right */\n}\n", "/* left */\na {\n  float: right; /* right */\n}\n"},
+	{"a{content:'left';float:left !important;}", "a{content:'left';float:right !important;}"},
+	{"a{float:left", "a{float:right"},
+	{"}a{float:left}", "}a{float:right}"},
+}
+
+func TestFlipper(t *testing.T) {
+	for _, test := range streamTests {
+		if out := flipStream(test.in, ""); out != test.out {
+			t.Errorf("%q: flipped to %q, want %q", test.in, out, test.out)
+		}
+	}
+}
+
+// flipping twice gives the original
+func TestFlipperTwice(t *testing.T) {
+	for _, test := range streamTests {
+		if out := flipStream(test.out, ""); out != test.in {
+			t.Errorf("%q: flipped back to %q", test.out, out)
+		}
+	}
+}